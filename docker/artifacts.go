@@ -0,0 +1,168 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// composeOutputFile the artifact the compose CLI's output is appended to
+	composeOutputFile = "compose.log"
+	// summaryFile the artifact summarizing the environment
+	summaryFile = "environment.json"
+)
+
+type (
+	// environmentSummary the content of the environment summary artifact
+	environmentSummary struct {
+		Project      string           `json:"project"`
+		ComposeFiles []string         `json:"composeFiles"`
+		Backend      string           `json:"backend"`
+		CapturedAt   time.Time        `json:"capturedAt"`
+		Services     []serviceSummary `json:"services"`
+		Errors       []string         `json:"errors,omitempty"`
+	}
+	serviceSummary struct {
+		Name       string             `json:"name"`
+		Containers []containerSummary `json:"containers"`
+	}
+	containerSummary struct {
+		Name   string `json:"name"`
+		ID     string `json:"id"`
+		Image  string `json:"image"`
+		State  string `json:"state"`
+		Status string `json:"status"`
+		// Stats the summaries of the container's resource usage recorders, if any (see Container.StartSampling)
+		Stats []StatsSummary `json:"stats,omitempty"`
+	}
+
+	// artifactsLogger tees the verbatim output of the compose CLI to an artifact file
+	artifactsLogger struct {
+		Logger
+		path string
+		lock sync.Mutex
+	}
+)
+
+// commandLogger the Logger the compose CLI's output goes to
+func (c *Compose) commandLogger() Logger {
+	if c.config.Env.ArtifactsDir == "" {
+		return c.log
+	}
+	return &artifactsLogger{Logger: c.log, path: filepath.Join(c.config.Env.ArtifactsDir, composeOutputFile)}
+}
+
+func (l *artifactsLogger) Print(color Color, text string) {
+	l.Logger.Print(color, text)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return
+	}
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, _ = fmt.Fprintln(file, text)
+}
+
+// captureArtifacts writes, for each container of the environment, its logs (<name>.log) and inspection
+// (<name>.inspect.json), plus a summary of the environment, to EnvironmentConfig.ArtifactsDir. Containers are named
+// after their service, unless it has several replicas
+func (e *Environment) captureArtifacts(ctx context.Context) {
+	dir := e.compose.config.Env.ArtifactsDir
+	if dir == "" {
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		e.compose.log.Errorf("could not create artifacts directory %s: %v", dir, err)
+		return
+	}
+	backend := string(e.compose.config.Env.Backend)
+	if backend == "" {
+		backend = "cli"
+	}
+	envSummary := environmentSummary{
+		Project:      e.compose.ProjectName(),
+		ComposeFiles: e.compose.config.Env.ComposeFilePaths,
+		Backend:      backend,
+		CapturedAt:   time.Now(),
+	}
+	stats := e.compose.sampling.summaries()
+	for _, name := range e.compose.getServiceNames() {
+		service := serviceSummary{Name: name}
+		containers, err := e.compose.GetContainersContext(ctx, name)
+		if err != nil {
+			envSummary.Errors = append(envSummary.Errors, fmt.Sprintf("service %s: %v", name, err))
+		}
+		for _, cntr := range containers {
+			service.Containers = append(service.Containers, containerSummary{
+				Name:   containerName(*cntr.Config),
+				ID:     cntr.Config.ID,
+				Image:  cntr.Config.Image,
+				State:  cntr.Config.State,
+				Status: cntr.Config.Status,
+				Stats:  stats[cntr.Config.ID],
+			})
+			base := name
+			if len(containers) > 1 {
+				base = containerName(*cntr.Config)
+			}
+			if err = cntr.writeLogsArtifact(ctx, filepath.Join(dir, base+".log")); err != nil {
+				envSummary.Errors = append(envSummary.Errors, fmt.Sprintf("logs of %s: %v", base, err))
+			}
+			if err = cntr.writeInspectArtifact(ctx, filepath.Join(dir, base+".inspect.json")); err != nil {
+				envSummary.Errors = append(envSummary.Errors, fmt.Sprintf("inspection of %s: %v", base, err))
+			}
+		}
+		envSummary.Services = append(envSummary.Services, service)
+	}
+	if err := writeJSON(filepath.Join(dir, summaryFile), envSummary); err != nil {
+		e.compose.log.Errorf("could not write environment summary: %v", err)
+	}
+	e.compose.log.Infof("wrote artifacts of project %s to %s", e.compose.ProjectName(), dir)
+}
+
+// writeLogsArtifact writes the container's logs, one timestamped and stream-tagged line at a time
+func (c *Container) writeLogsArtifact(ctx context.Context, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// also stops the stream on early returns
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lines, err := c.streamLogs(ctx, LogOptions{}, false)
+	if err != nil {
+		return err
+	}
+	for line := range lines {
+		if _, err = fmt.Fprintf(file, "%s %s %s\n", line.Timestamp.Format(time.RFC3339Nano), line.Stream, line.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Container) writeInspectArtifact(ctx context.Context, path string) error {
+	inspection, err := c.cli.ContainerInspect(ctx, c.Config.ID)
+	if err != nil {
+		return err
+	}
+	return writeJSON(path, inspection)
+}
+
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,59 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+)
+
+type (
+	// Backend selects how the compose lifecycle (up/stop/down) is carried out
+	Backend string
+
+	// composeBackend performs the lifecycle operations of a Compose. Waiting for the resulting container states is
+	// left to the Compose
+	composeBackend interface {
+		// up creates and starts the given services (all if none given). renewVolumes recreates anonymous volumes
+		up(ctx context.Context, services []string, renewVolumes bool) error
+		// stop stops and removes the containers of the given services
+		stop(ctx context.Context, services []string) error
+		// down removes all containers, networks and volumes of the project
+		down(ctx context.Context) error
+	}
+
+	// cliBackend shells out to the docker-compose CLI
+	cliBackend struct {
+		compose *Compose
+	}
+)
+
+const (
+	// BackendCLI runs the docker-compose CLI (default)
+	BackendCLI Backend = ""
+	// BackendEngine parses the compose files in-process and talks directly to the Docker Engine API. No compose
+	// CLI needs to be installed
+	BackendEngine Backend = "engine"
+)
+
+func (b *cliBackend) up(ctx context.Context, services []string, renewVolumes bool) error {
+	args := []string{"-p", b.compose.project, "up", "-d"}
+	if renewVolumes {
+		args = append(args, "--renew-anon-volumes")
+	}
+	for _, service := range services {
+		if cfg := b.compose.config.Services[service]; cfg != nil && cfg.Replicas > 0 {
+			args = append(args, "--scale", fmt.Sprintf("%s=%d", service, cfg.Replicas))
+		}
+	}
+	cmd := b.compose.command(ctx, append(args, services...)...)
+	cmd.Env = b.compose.getEnvVariables()
+	return runCommand(ctx, b.compose.commandLogger(), cmd)
+}
+
+func (b *cliBackend) stop(ctx context.Context, services []string) error {
+	args := append([]string{"-p", b.compose.project, "rm", "-s", "-f"}, services...)
+	return runCommand(ctx, b.compose.commandLogger(), b.compose.command(ctx, args...))
+}
+
+func (b *cliBackend) down(ctx context.Context) error {
+	return runCommand(ctx, b.compose.commandLogger(), b.compose.command(ctx, "-p", b.compose.project, "down", "-v"))
+}
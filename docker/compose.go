@@ -2,11 +2,15 @@ package docker
 
 import (
 	"context"
-	"errors"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/docker/docker/api/types/container"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,13 +18,40 @@ import (
 	"github.com/docker/docker/client"
 )
 
-const dockerComposeBin = "docker-compose"
-
 type (
 	// Compose an API to access docker-compose
 	Compose struct {
 		cli    *client.Client
 		config ComposeConfig
+		// bin the argv prefix used to invoke the compose CLI (e.g. ["docker", "compose"])
+		bin []string
+		// project the resolved compose project name all calls are scoped to
+		project string
+		backend composeBackend
+		// tracker the container state view shared by the await functions and containers, started on first use
+		tracker     *stateTracker
+		trackerOnce sync.Once
+		// hashes the services' configuration hashes, computed when reusing containers (see EnvironmentConfig.Reuse)
+		hashes map[string]string
+		// log where the compose output goes
+		log Logger
+		// shaped the containers whose traffic is shaped (see Container.ShapeTraffic)
+		shaped *shapedContainers
+		// sampling the recorders of the containers' resource usage (see Container.StartSampling)
+		sampling *statsRecorders
+		// overrideFile the generated compose file labelling the containers with their hashes, for the CLI backend
+		overrideFile string
+	}
+
+	// ComposeCommand the flavour of the compose CLI to invoke
+	ComposeCommand string
+
+	// ComposeNotFoundError returned when none of the compose CLIs tried could be run
+	ComposeNotFoundError struct {
+		// Tried the commands that were attempted, in order
+		Tried []ComposeCommand
+		// Errs the error each attempt failed with, index-aligned with Tried
+		Errs []error
 	}
 
 	// EnvironmentConfig global-level (i.e. for all containers) config for the testing framework
@@ -31,12 +62,41 @@ type (
 		DownTimeout time.Duration
 		// ComposeFilePaths the path to the compose-YAML file(s)
 		ComposeFilePaths []string
-		// Optional custom container label name
+		// Label optional extra container label (e.g. "integration") that the service containers must carry. Containers
+		// are identified by their compose project and service labels regardless
 		Label string
 		// If true it will ignore any existing containers that are running due to a previous run
 		NoCleanup bool
 		// If true it will not shut down the containers after the test
 		NoShutdown bool
+		// ComposeCommand which compose CLI to use (optional). If not set, it is detected at startup,
+		// preferring the v2 plugin ("docker compose") over the legacy binary ("docker-compose")
+		ComposeCommand ComposeCommand
+		// ProjectName the compose project name (optional). Defaults to ProjectID
+		ProjectName string
+		// UniqueProjectName if true and ProjectName is not set, a unique project name is generated for each
+		// environment so that environments can run concurrently on the same docker daemon
+		UniqueProjectName bool
+		// Backend how the compose lifecycle is managed (optional). Defaults to BackendCLI
+		Backend Backend
+		// StreamLogs if true, the services' logs are streamed to stdout while the environment is up, each line
+		// prefixed with its (colored) service name. The logs are then not printed again on shutdown
+		StreamLogs bool
+		// Reap if true, a watchdog process removes the project's containers, networks and volumes should the test
+		// process die (e.g. a panic, a "go test -timeout" or Ctrl-C) before shutting the environment down. Requires
+		// RunReaperIfChild to be called from TestMain. Ignored with NoShutdown
+		Reap bool
+		// Logger optional Logger for the environment's output, including the compose CLI's and the containers' logs.
+		// Defaults to the global one (see SetLogger)
+		Logger Logger
+		// ArtifactsDir optional directory the environment's diagnostics are written to on shutdown, or when it fails to
+		// start: the logs (<service>.log) and inspection (<service>.inspect.json) of each container, the compose CLI's
+		// output (compose.log) and a summary (environment.json). Use a distinct directory per environment
+		ArtifactsDir string
+		// Reuse if true, the containers are left running on shutdown and reattached to by later runs, as long as their
+		// service's configuration (compose file definition, env variables, image and replicas) hasn't changed. The
+		// services that did change are recreated. Implies NoCleanup and NoShutdown
+		Reuse bool
 	}
 	// ServiceConfig service/container-level config needed for docker-compose purposes
 	ServiceConfig struct {
@@ -46,6 +106,11 @@ type (
 		EnvironmentVars map[string]string
 		// Optional custom network name
 		Network string
+		// WaitFor optional readiness strategy, evaluated once the container is running (and healthy, if it has a
+		// health-check)
+		WaitFor WaitStrategy
+		// Replicas optional number of containers to scale the service to. If not set, the compose file decides
+		Replicas int
 	}
 	// ComposeConfig config needed to get docker-compose and the testing framework going
 	ComposeConfig struct {
@@ -56,22 +121,64 @@ type (
 	}
 )
 
+const (
+	// ComposeAuto detect the installed compose CLI
+	ComposeAuto ComposeCommand = ""
+	// ComposeV1 the legacy standalone docker-compose binary
+	ComposeV1 ComposeCommand = "docker-compose"
+	// ComposeV2 the docker compose CLI plugin
+	ComposeV2 ComposeCommand = "docker compose"
+)
+
+func (e *ComposeNotFoundError) Error() string {
+	var tried []string
+	for i, cmd := range e.Tried {
+		tried = append(tried, fmt.Sprintf("\"%s\": %v", cmd, e.Errs[i]))
+	}
+	return fmt.Sprintf("no usable compose CLI found. tried: [%s]", strings.Join(tried, ", "))
+}
+
 func NewCompose(params ComposeConfig) (*Compose, error) {
 	if len(params.Env.ComposeFilePaths) == 0 {
 		return nil, fmt.Errorf("at least one compose file must be specified")
 	}
-	if params.Env.Label == "" {
-		params.Env.Label = DefaultLabel
-	}
 	for _, path := range params.Env.ComposeFilePaths {
 		if _, err := os.Stat(path); os.IsNotExist(err) {
 			return nil, fmt.Errorf("compose file not found at %s", path)
 		}
 	}
 	compose := Compose{
-		config: params,
+		config:   params,
+		project:  params.Env.ProjectName,
+		log:      params.Env.Logger,
+		shaped:   &shapedContainers{containers: make(map[string]*Container)},
+		sampling: &statsRecorders{},
+	}
+	if compose.log == nil {
+		compose.log = globalLogger()
 	}
 	var err error
+	switch params.Env.Backend {
+	case BackendCLI:
+		compose.bin, err = detectComposeCommand(params.Env.ComposeCommand)
+		if err != nil {
+			return nil, err
+		}
+		compose.backend = &cliBackend{compose: &compose}
+	case BackendEngine:
+		compose.backend = &engineBackend{compose: &compose}
+	default:
+		return nil, fmt.Errorf("unknown compose backend %s", params.Env.Backend)
+	}
+	if compose.project == "" {
+		compose.project = ProjectID
+		if params.Env.UniqueProjectName {
+			compose.project, err = uniqueProjectName()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 	compose.cli, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, err
@@ -80,111 +187,178 @@ func NewCompose(params ComposeConfig) (*Compose, error) {
 }
 
 func (c *Compose) Up() error {
-	pathsArgs := c.getComposeFileArgs()
-	args := append(pathsArgs, []string{"-p", ProjectID, "up", "-d", "--renew-anon-volumes"}...)
-	args = append(args, c.getServiceNames()...)
-	cmd := exec.Command(dockerComposeBin, args...)
-	cmd.Env = c.getEnvVariables()
-	startTime := time.Now()
-	if err := runCommand(cmd, c.config.Env.UpTimeout); err != nil {
+	return c.UpContext(context.Background())
+}
+
+// UpContext like Up, but stops bringing up the services once ctx is done
+func (c *Compose) UpContext(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Env.UpTimeout)
+	defer cancel()
+	if err := c.upServices(ctx, c.getServiceNames(), true); err != nil {
 		return err
 	}
-	timeout := c.config.Env.UpTimeout - time.Since(startTime)
-	if err := awaitState(c.getServiceConfigs(), timeout, c.awaitStart); err != nil {
+	if err := awaitState(ctx, c.getServiceConfigs(), c.awaitStart); err != nil {
 		return fmt.Errorf("error with compose-up: %w", err)
 	}
-	logger.Infof("Brought up services %v", c.getServiceNames())
+	c.log.Infof("Brought up services %v", c.getServiceNames())
 	return nil
 }
 
 func (c *Compose) Start(services ...*ServiceConfig) error {
+	return c.StartContext(context.Background(), services...)
+}
+
+// StartContext like Start, but stops starting the services once ctx is done
+func (c *Compose) StartContext(ctx context.Context, services ...*ServiceConfig) error {
 	if len(services) == 0 {
 		return nil
 	}
 	c.addServiceConfigs(services...)
-	pathsArgs := c.getComposeFileArgs()
-	args := append(pathsArgs, []string{"-p", ProjectID, "up", "-d"}...)
-	args = append(args, c.getServiceNames(services...)...)
-	cmd := exec.Command(dockerComposeBin, args...)
-	cmd.Env = c.getEnvVariables()
-	startTime := time.Now()
-	if err := runCommand(cmd, c.config.Env.UpTimeout); err != nil {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Env.UpTimeout)
+	defer cancel()
+	if err := c.upServices(ctx, c.getServiceNames(services...), false); err != nil {
 		return err
 	}
-	timeout := c.config.Env.UpTimeout - time.Since(startTime)
-	if err := awaitState(services, timeout, c.awaitStart); err != nil {
+	if err := awaitState(ctx, services, c.awaitStart); err != nil {
 		return fmt.Errorf("error with compose-up: %w", err)
 	}
-	logger.Infof("started services %v", c.getServiceNames())
+	c.log.Infof("started services %v", c.getServiceNames())
 	return nil
 }
 
 func (c *Compose) Stop(services ...string) error {
-	pathsArgs := c.getComposeFileArgs()
-	args := append(pathsArgs, []string{"-p", ProjectID, "rm", "-s", "-f"}...)
-	args = append(args, services...)
-	cmd := exec.Command(dockerComposeBin, args...)
-	startTime := time.Now()
-	if err := runCommand(cmd, c.config.Env.DownTimeout); err != nil {
+	return c.StopContext(context.Background(), services...)
+}
+
+// StopContext like Stop, but stops waiting for the services once ctx is done
+func (c *Compose) StopContext(ctx context.Context, services ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Env.DownTimeout)
+	defer cancel()
+	if err := c.backend.stop(ctx, services); err != nil {
 		return err
 	}
-	timeout := c.config.Env.UpTimeout - time.Since(startTime)
-	if err := awaitState(c.getServiceConfigs(services...), timeout, c.awaitStop); err != nil {
+	if err := awaitState(ctx, c.getServiceConfigs(services...), c.awaitStop); err != nil {
 		return fmt.Errorf("error with compose-down: %w", err)
 	}
-	logger.Infof("stopped services %v", c.getServiceNames())
+	c.log.Infof("stopped services %v", c.getServiceNames())
 	return nil
 }
 
 func (c *Compose) Down() error {
-	pathsArgs := c.getComposeFileArgs()
-	args := append(pathsArgs, []string{"-p", ProjectID, "down", "-v"}...)
-	cmd := exec.Command(dockerComposeBin, args...)
-	startTime := time.Now()
-	if err := runCommand(cmd, c.config.Env.DownTimeout); err != nil {
+	return c.DownContext(context.Background())
+}
+
+// DownContext like Down, but stops waiting for the services once ctx is done
+func (c *Compose) DownContext(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Env.DownTimeout)
+	defer cancel()
+	if err := c.backend.down(ctx); err != nil {
 		return err
 	}
-	timeout := c.config.Env.UpTimeout - time.Since(startTime)
-	if err := awaitState(c.getServiceConfigs(), timeout, c.awaitStop); err != nil {
+	if err := awaitState(ctx, c.getServiceConfigs(), c.awaitStop); err != nil {
 		return fmt.Errorf("error with compose-down: %w", err)
 	}
-	logger.Infof("Brought down services %v", c.getServiceNames())
+	c.log.Infof("Brought down services %v", c.getServiceNames())
 	return nil
 }
 
 func (c *Compose) GetContainer(service string) (*Container, error) {
-	list, err := c.cli.ContainerList(context.Background(), container.ListOptions{
-		All: true,
-		Filters: filters.NewArgs(
-			filters.Arg("label", c.config.Env.Label),
-			filters.Arg("name", service),
-		),
-	})
+	return c.GetContainerContext(context.Background(), service)
+}
+
+// GetContainerContext like GetContainer, with a context for the Docker API call
+func (c *Compose) GetContainerContext(ctx context.Context, service string) (*Container, error) {
+	containers, err := c.GetContainersContext(ctx, service)
 	if err != nil {
 		return nil, err
 	}
-	if len(list) == 0 {
+	if len(containers) == 0 {
 		return nil, nil
-	} else if len(list) > 1 {
-		return nil, errors.New("Returned incorrect count of containers for service " + service)
-	}
-	return &Container{
-		cli:           c.cli,
-		Config:        &list[0],
-		ServiceConfig: c.config.Services[service],
-	}, nil
+	} else if len(containers) > 1 {
+		return nil, fmt.Errorf("found %d containers for service %s. use GetContainers for scaled services", len(containers), service)
+	}
+	return containers[0], nil
+}
+
+// GetContainers returns all the containers (replicas) of the service, ordered by their container number
+func (c *Compose) GetContainers(service string) ([]*Container, error) {
+	return c.GetContainersContext(context.Background(), service)
+}
+
+// GetContainersContext like GetContainers, with a context for the Docker API call
+func (c *Compose) GetContainersContext(ctx context.Context, service string) ([]*Container, error) {
+	args := filters.NewArgs(
+		filters.Arg("label", ProjectLabel+"="+c.project),
+		filters.Arg("label", ServiceLabel+"="+service),
+	)
+	if c.config.Env.Label != "" {
+		args.Add("label", c.config.Env.Label)
+	}
+	list, err := c.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return containerNumber(list[i]) < containerNumber(list[j])
+	})
+	var containers []*Container
+	for i := range list {
+		containers = append(containers, &Container{
+			cli:           c.cli,
+			log:           c.log,
+			shaped:        c.shaped,
+			sampling:      c.sampling,
+			tracker:       c.stateTracker(),
+			Config:        &list[i],
+			ServiceConfig: c.config.Services[service],
+		})
+	}
+	return containers, nil
+}
+
+// Close releases the resources held for tracking container states. The containers are left untouched
+func (c *Compose) Close() {
+	c.trackerOnce.Do(func() {
+		// never started: make sure it won't be, and that consumers poll instead
+		c.tracker = &stateTracker{broken: true, changed: make(chan struct{}), cancel: func() {}}
+	})
+	c.tracker.close()
+	if c.overrideFile != "" {
+		_ = os.Remove(c.overrideFile)
+	}
+}
+
+// stateTracker the project's container state tracker, subscribing to the Docker events on first use
+func (c *Compose) stateTracker() *stateTracker {
+	c.trackerOnce.Do(func() {
+		labels := []string{ProjectLabel + "=" + c.project}
+		if c.config.Env.Label != "" {
+			labels = append(labels, c.config.Env.Label)
+		}
+		c.tracker = newStateTracker(c.cli, c.log, labels...)
+	})
+	return c.tracker
 }
 
-func awaitState(services []*ServiceConfig, timeout time.Duration, serviceFn func(service *ServiceConfig, timeout <-chan time.Time) error) error {
+// ProjectName the compose project name this instance's containers are scoped to
+func (c *Compose) ProjectName() string {
+	return c.project
+}
+
+func awaitState(ctx context.Context, services []*ServiceConfig, serviceFn func(ctx context.Context, service *ServiceConfig) error) error {
 	pool := new(sync.WaitGroup)
-	waiter := make(chan interface{})
+	waiter := make(chan interface{}, len(services)+1)
 	errorMap := new(sync.Map)
 	pool.Add(len(services))
-	timer := time.After(timeout)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, service := range services {
 		service := service
 		go func() {
-			err := serviceFn(service, timer)
+			err := serviceFn(ctx, service)
 			if err != nil {
 				errorMap.Store(service.Name, err)
 				waiter <- nil
@@ -203,59 +377,96 @@ func awaitState(services []*ServiceConfig, timeout time.Duration, serviceFn func
 	return nil
 }
 
-func (c *Compose) awaitStart(service *ServiceConfig, timeout <-chan time.Time) error {
+func (c *Compose) awaitStart(ctx context.Context, service *ServiceConfig) error {
+	tracker := c.stateTracker()
+	expected := service.Replicas
+	if expected < 1 {
+		expected = 1
+	}
 	for {
-		cntr, e := c.GetContainer(service.Name)
-		if e != nil {
-			return fmt.Errorf("error getting container for %s: %w", service, e)
+		changed := tracker.changes()
+		var cntrs []*Container
+		if tracker.isBroken() || tracker.hasContainer(service.Name) {
+			var e error
+			cntrs, e = c.GetContainersContext(ctx, service.Name)
+			if e != nil {
+				return fmt.Errorf("error getting containers for %s: %w", service.Name, e)
+			}
 		}
-		if cntr != nil {
-			status := cntr.GetStatus()
+		started := 0
+		for _, cntr := range cntrs {
+			status := cntr.GetStatusContext(ctx)
 			if err := status.Error; err != nil {
 				return err
 			}
 			if !(status.Code == Unhealthy || status.Code == NotReady) {
-				return nil
+				started++
 			}
 		}
+		if started >= expected && started == len(cntrs) {
+			return c.awaitReady(ctx, service, cntrs)
+		}
 		select {
-		case <-timeout:
-			if cntr != nil {
+		case <-changed:
+		case <-tracker.pollFallback():
+		case <-ctx.Done():
+			for _, cntr := range cntrs {
 				PrintLogs(YELLOW, cntr)
 				PrintContainerState(YELLOW, cntr)
 			}
-			return fmt.Errorf("service %s startup timed out", service.Name)
-		default:
-			time.Sleep(500 * time.Millisecond)
+			return fmt.Errorf("service %s startup timed out. %d of %d containers started", service.Name, started, expected)
 		}
 	}
 }
 
-func (c *Compose) awaitStop(service *ServiceConfig, timeout <-chan time.Time) error {
-	for {
-		cntr, e := c.GetContainer(service.Name)
-		if e != nil {
-			return fmt.Errorf("error getting container for %s: %w", service, e)
+// awaitReady evaluates the service's wait strategy, if any, against each of its running containers
+func (c *Compose) awaitReady(ctx context.Context, service *ServiceConfig, cntrs []*Container) error {
+	if service.WaitFor == nil {
+		return nil
+	}
+	for _, cntr := range cntrs {
+		if err := service.WaitFor.WaitUntilReady(ctx, cntr); err != nil {
+			PrintLogs(YELLOW, cntr)
+			PrintContainerState(YELLOW, cntr)
+			return fmt.Errorf("service %s (container %s) did not become ready: %w", service.Name, cntr.Config.Names[0], err)
 		}
-		if cntr == nil {
+	}
+	return nil
+}
+
+func (c *Compose) awaitStop(ctx context.Context, service *ServiceConfig) error {
+	tracker := c.stateTracker()
+	for {
+		changed := tracker.changes()
+		if !tracker.isBroken() && !tracker.hasRunning(service.Name) {
 			return nil
 		}
-		status := cntr.GetStatus()
-		if err := status.Error; err != nil {
-			return err
+		cntrs, e := c.GetContainersContext(ctx, service.Name)
+		if e != nil {
+			return fmt.Errorf("error getting containers for %s: %w", service.Name, e)
+		}
+		var running []*Container
+		for _, cntr := range cntrs {
+			status := cntr.GetStatusContext(ctx)
+			if err := status.Error; err != nil {
+				return err
+			}
+			if status.Code == Running {
+				running = append(running, cntr)
+			}
 		}
-		if status.Code != Running {
+		if len(running) == 0 {
 			return nil
 		}
 		select {
-		case <-timeout:
-			if cntr != nil {
+		case <-changed:
+		case <-tracker.pollFallback():
+		case <-ctx.Done():
+			for _, cntr := range running {
 				PrintLogs(YELLOW, cntr)
 				PrintContainerState(YELLOW, cntr)
 			}
 			return fmt.Errorf("service %s shutdown timed out", service.Name)
-		default:
-			time.Sleep(500 * time.Millisecond)
 		}
 	}
 }
@@ -312,11 +523,67 @@ func (c *Compose) getServiceConfigs(services ...string) []*ServiceConfig {
 	return configs
 }
 
+// command builds the compose CLI invocation for the given arguments, including the compose file flags. The process
+// is killed once ctx is done
+func (c *Compose) command(ctx context.Context, args ...string) *exec.Cmd {
+	var argv []string
+	argv = append(argv, c.bin[1:]...)
+	argv = append(argv, c.getComposeFileArgs()...)
+	argv = append(argv, args...)
+	return exec.CommandContext(ctx, c.bin[0], argv...)
+}
+
+// detectComposeCommand resolves the argv prefix of the compose CLI to use. An explicit choice is only verified,
+// otherwise the candidates are tried in order of preference
+func detectComposeCommand(choice ComposeCommand) ([]string, error) {
+	candidates := []ComposeCommand{ComposeV2, ComposeV1}
+	if choice != ComposeAuto {
+		candidates = []ComposeCommand{choice}
+	}
+	notFound := &ComposeNotFoundError{}
+	for _, candidate := range candidates {
+		bin := strings.Fields(string(candidate))
+		if len(bin) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		out, err := exec.CommandContext(ctx, bin[0], append(bin[1:], "version")...).CombinedOutput()
+		cancel()
+		if err == nil {
+			return bin, nil
+		}
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		notFound.Tried = append(notFound.Tried, candidate)
+		notFound.Errs = append(notFound.Errs, err)
+	}
+	return nil, notFound
+}
+
+// containerNumber the replica number compose assigned to the container
+func containerNumber(cntr container.Summary) int {
+	number, _ := strconv.Atoi(cntr.Labels[containerNumberLabel])
+	return number
+}
+
+// uniqueProjectName generates a project name that is distinct per process and per call
+func uniqueProjectName() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("could not generate project name: %w", err)
+	}
+	return fmt.Sprintf("%s-%d-%s", ProjectID, os.Getpid(), hex.EncodeToString(suffix)), nil
+}
+
 func (c *Compose) getComposeFileArgs() []string {
 	var cmd []string
 	for _, path := range c.config.Env.ComposeFilePaths {
 		cmd = append(cmd, "-f")
 		cmd = append(cmd, path)
 	}
+	if c.overrideFile != "" {
+		cmd = append(cmd, "-f", c.overrideFile)
+	}
 	return cmd
 }
@@ -0,0 +1,640 @@
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// composeFile the subset of the compose specification understood by the engine backend
+	composeFile struct {
+		Version  string                     `yaml:"version"`
+		Services map[string]*composeService `yaml:"services"`
+		Networks map[string]*composeNetwork `yaml:"networks"`
+		Volumes  map[string]*composeVolume  `yaml:"volumes"`
+		// dir the directory relative paths in the file are resolved against
+		dir string
+	}
+	composeService struct {
+		Image           string             `yaml:"image"`
+		ContainerName   string             `yaml:"container_name"`
+		Command         commandSpec        `yaml:"command"`
+		Entrypoint      commandSpec        `yaml:"entrypoint"`
+		Environment     mappingOrList      `yaml:"environment"`
+		EnvFile         stringOrList       `yaml:"env_file"`
+		Labels          mappingOrList      `yaml:"labels"`
+		Networks        serviceNetworks    `yaml:"networks"`
+		Ports           []string           `yaml:"ports"`
+		Expose          []string           `yaml:"expose"`
+		Volumes         []string           `yaml:"volumes"`
+		Tmpfs           stringOrList       `yaml:"tmpfs"`
+		Healthcheck     *composeHealth     `yaml:"healthcheck"`
+		DependsOn       dependsOn          `yaml:"depends_on"`
+		WorkingDir      string             `yaml:"working_dir"`
+		User            string             `yaml:"user"`
+		Hostname        string             `yaml:"hostname"`
+		Privileged      bool               `yaml:"privileged"`
+		CapAdd          []string           `yaml:"cap_add"`
+		CapDrop         []string           `yaml:"cap_drop"`
+		ExtraHosts      stringOrList       `yaml:"extra_hosts"`
+		Restart         string             `yaml:"restart"`
+		StopSignal      string             `yaml:"stop_signal"`
+		StopGracePeriod string             `yaml:"stop_grace_period"`
+		Sysctls         mappingOrList      `yaml:"sysctls"`
+		Deploy          *composeDeployment `yaml:"deploy"`
+	}
+	composeDeployment struct {
+		Replicas *int `yaml:"replicas"`
+	}
+	composeHealth struct {
+		Test        stringOrList `yaml:"test"`
+		Interval    string       `yaml:"interval"`
+		Timeout     string       `yaml:"timeout"`
+		StartPeriod string       `yaml:"start_period"`
+		Retries     int          `yaml:"retries"`
+		Disable     bool         `yaml:"disable"`
+	}
+	composeNetwork struct {
+		Name     string        `yaml:"name"`
+		Driver   string        `yaml:"driver"`
+		External bool          `yaml:"external"`
+		Internal bool          `yaml:"internal"`
+		Labels   mappingOrList `yaml:"labels"`
+	}
+	composeVolume struct {
+		Name     string        `yaml:"name"`
+		Driver   string        `yaml:"driver"`
+		External bool          `yaml:"external"`
+		Labels   mappingOrList `yaml:"labels"`
+	}
+	serviceNetwork struct {
+		Aliases     []string `yaml:"aliases"`
+		IPv4Address string   `yaml:"ipv4_address"`
+	}
+
+	// commandSpec a command given either as a string (shell-split) or as a list
+	commandSpec []string
+	// stringOrList a value given either as a single string or as a list of strings
+	stringOrList []string
+	// mappingOrList key-values given either as a map or as a list of "key=value" strings. Keys without a value
+	// map to nil
+	mappingOrList map[string]*string
+	// serviceNetworks the networks of a service, given either as a list of names or as a map of name to settings
+	serviceNetworks map[string]*serviceNetwork
+	// dependsOn the service dependencies, given either as a list of names or as a map of name to conditions
+	dependsOn []string
+)
+
+var interpolationPattern = regexp.MustCompile(`\$\$|\$\{([^}]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// loadComposeFiles parses and merges the compose files in order, interpolating variables from env
+func loadComposeFiles(paths []string, env []string) (*composeFile, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("at least one compose file must be specified")
+	}
+	dir, err := filepath.Abs(filepath.Dir(paths[0]))
+	if err != nil {
+		return nil, err
+	}
+	vars, err := readDotEnv(filepath.Join(dir, ".env"))
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			vars[k] = v
+		}
+	}
+	merged := &composeFile{
+		Services: make(map[string]*composeService),
+		Networks: make(map[string]*composeNetwork),
+		Volumes:  make(map[string]*composeVolume),
+		dir:      dir,
+	}
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		file, err := parseComposeFile(raw, vars)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing compose file %s: %w", path, err)
+		}
+		merged.merge(file)
+	}
+	for name, service := range merged.Services {
+		if err = service.loadEnvFiles(dir); err != nil {
+			return nil, fmt.Errorf("error loading env_file for service %s: %w", name, err)
+		}
+		for key, value := range service.Environment {
+			if value == nil {
+				if v, ok := vars[key]; ok {
+					service.Environment[key] = &v
+				}
+			}
+		}
+	}
+	return merged, nil
+}
+
+// parseComposeFile parses the content, then interpolates its values like compose does: comments and keys are left as
+// is, and substituted values can't change the structure of the file
+func parseComposeFile(content []byte, vars map[string]string) (*composeFile, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	file := new(composeFile)
+	if document.Kind == 0 {
+		return file, nil // empty
+	}
+	if err := interpolateNode(&document, vars); err != nil {
+		return nil, err
+	}
+	if err := document.Decode(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// interpolateNode interpolates the scalar values of the node and its descendants, but not the mapping keys
+func interpolateNode(node *yaml.Node, vars map[string]string) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		value, err := interpolate(node.Value, vars)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value != node.Value && node.Style == 0 {
+			// unquoted: the type is that of the substituted value (e.g. a number for replicas), as if written as is
+			node.Tag = ""
+		}
+		node.Value = value
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateNode(node.Content[i], vars); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			if err := interpolateNode(child, vars); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate substitutes ${VAR}, ${VAR:-default}, ${VAR-default}, ${VAR:?err}, ${VAR?err} and $VAR, and unescapes $$
+func interpolate(content string, vars map[string]string) (string, error) {
+	var err error
+	result := interpolationPattern.ReplaceAllStringFunc(content, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		groups := interpolationPattern.FindStringSubmatch(match)
+		if groups[2] != "" {
+			return vars[groups[2]]
+		}
+		expr := groups[1]
+		for _, op := range []string{":-", ":?", "-", "?"} {
+			name, arg, ok := strings.Cut(expr, op)
+			if !ok {
+				continue
+			}
+			value, set := vars[name]
+			unset := !set || (strings.HasPrefix(op, ":") && value == "")
+			if !unset {
+				return value
+			}
+			if strings.HasSuffix(op, "?") {
+				err = fmt.Errorf("required variable %s is missing a value: %s", name, arg)
+				return ""
+			}
+			return arg
+		}
+		return vars[expr]
+	})
+	return result, err
+}
+
+// readDotEnv reads the optional .env file of the project directory
+func readDotEnv(path string) (map[string]string, error) {
+	vars := make(map[string]string)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return vars, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, _ := strings.Cut(line, "=")
+		vars[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+	}
+	return vars, scanner.Err()
+}
+
+// requireImages checks that every service has an image, since building images is not supported
+func (f *composeFile) requireImages() error {
+	for name, service := range f.Services {
+		if service.Image == "" {
+			return fmt.Errorf("service %s has no image. building images is not supported by the engine backend", name)
+		}
+	}
+	return nil
+}
+
+func (f *composeFile) merge(other *composeFile) {
+	if f.Version == "" {
+		f.Version = other.Version
+	}
+	for name, network := range other.Networks {
+		if network == nil {
+			network = new(composeNetwork)
+		}
+		f.Networks[name] = network
+	}
+	for name, volume := range other.Volumes {
+		if volume == nil {
+			volume = new(composeVolume)
+		}
+		f.Volumes[name] = volume
+	}
+	for name, service := range other.Services {
+		if service == nil {
+			service = new(composeService)
+		}
+		existing, ok := f.Services[name]
+		if !ok {
+			f.Services[name] = service
+			continue
+		}
+		existing.merge(service)
+	}
+}
+
+// merge overrides the scalar fields set in other, merges the mappings, and appends the sequences
+func (s *composeService) merge(other *composeService) {
+	overrideString := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	overrideString(&s.Image, other.Image)
+	overrideString(&s.ContainerName, other.ContainerName)
+	overrideString(&s.WorkingDir, other.WorkingDir)
+	overrideString(&s.User, other.User)
+	overrideString(&s.Hostname, other.Hostname)
+	overrideString(&s.Restart, other.Restart)
+	overrideString(&s.StopSignal, other.StopSignal)
+	overrideString(&s.StopGracePeriod, other.StopGracePeriod)
+	if other.Command != nil {
+		s.Command = other.Command
+	}
+	if other.Entrypoint != nil {
+		s.Entrypoint = other.Entrypoint
+	}
+	if other.Healthcheck != nil {
+		s.Healthcheck = other.Healthcheck
+	}
+	if other.Deploy != nil {
+		s.Deploy = other.Deploy
+	}
+	s.Privileged = s.Privileged || other.Privileged
+	s.Environment = s.Environment.merge(other.Environment)
+	s.Labels = s.Labels.merge(other.Labels)
+	s.Sysctls = s.Sysctls.merge(other.Sysctls)
+	if s.Networks == nil {
+		s.Networks = other.Networks
+	} else {
+		for name, network := range other.Networks {
+			s.Networks[name] = network
+		}
+	}
+	s.EnvFile = append(s.EnvFile, other.EnvFile...)
+	s.Ports = append(s.Ports, other.Ports...)
+	s.Expose = append(s.Expose, other.Expose...)
+	s.Volumes = append(s.Volumes, other.Volumes...)
+	s.Tmpfs = append(s.Tmpfs, other.Tmpfs...)
+	s.DependsOn = append(s.DependsOn, other.DependsOn...)
+	s.CapAdd = append(s.CapAdd, other.CapAdd...)
+	s.CapDrop = append(s.CapDrop, other.CapDrop...)
+	s.ExtraHosts = append(s.ExtraHosts, other.ExtraHosts...)
+}
+
+// loadEnvFiles adds the variables of the service's env_file(s) that aren't already in its environment
+func (s *composeService) loadEnvFiles(dir string) error {
+	for _, path := range s.EnvFile {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		vars, err := readDotEnv(path)
+		if err != nil {
+			return err
+		}
+		if s.Environment == nil {
+			s.Environment = make(mappingOrList)
+		}
+		for k, v := range vars {
+			v := v
+			if _, ok := s.Environment[k]; !ok {
+				s.Environment[k] = &v
+			}
+		}
+	}
+	return nil
+}
+
+// env the service's environment as a sorted list of "key=value" pairs. Keys without a value are left out
+func (s *composeService) env() []string {
+	var env []string
+	for k, v := range s.Environment {
+		if v != nil {
+			env = append(env, k+"="+*v)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// replicas the number of containers requested by deploy.replicas, defaulting to 1
+func (s *composeService) replicas() int {
+	if s.Deploy == nil || s.Deploy.Replicas == nil {
+		return 1
+	}
+	return *s.Deploy.Replicas
+}
+
+// networkNames the service's network keys in a stable order, falling back to the implicit default network
+func (s *composeService) networkNames() []string {
+	if len(s.Networks) == 0 {
+		return []string{"default"}
+	}
+	var names []string
+	for name := range s.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serviceOrder resolves the given services and their transitive dependencies, ordered so that dependencies come
+// first. If no services are given, all services are included
+func (f *composeFile) serviceOrder(services ...string) ([]string, error) {
+	if len(services) == 0 {
+		for name := range f.Services {
+			services = append(services, name)
+		}
+	}
+	sort.Strings(services)
+	var order []string
+	state := make(map[string]int) // 1: visiting, 2: visited
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("circular dependency involving service %s", name)
+		case 2:
+			return nil
+		}
+		service, ok := f.Services[name]
+		if !ok {
+			return fmt.Errorf("no such service: %s", name)
+		}
+		state[name] = 1
+		deps := append([]string(nil), service.DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range services {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+func (h *composeHealth) durations() (interval, timeout, startPeriod time.Duration, err error) {
+	parse := func(s string) (time.Duration, error) {
+		if s == "" {
+			return 0, nil
+		}
+		return time.ParseDuration(s)
+	}
+	if interval, err = parse(h.Interval); err != nil {
+		return
+	}
+	if timeout, err = parse(h.Timeout); err != nil {
+		return
+	}
+	startPeriod, err = parse(h.StartPeriod)
+	return
+}
+
+func (m mappingOrList) merge(other mappingOrList) mappingOrList {
+	if m == nil {
+		return other
+	}
+	for k, v := range other {
+		m[k] = v
+	}
+	return m
+}
+
+// values the mapping with keys lacking a value mapped to the empty string
+func (m mappingOrList) values() map[string]string {
+	values := make(map[string]string)
+	for k, v := range m {
+		if v == nil {
+			values[k] = ""
+		} else {
+			values[k] = *v
+		}
+	}
+	return values
+}
+
+func (c *commandSpec) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		words, err := splitCommand(node.Value)
+		if err != nil {
+			return err
+		}
+		*c = words
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+func (s *stringOrList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = []string{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+func (m *mappingOrList) UnmarshalYAML(node *yaml.Node) error {
+	mapping := make(mappingOrList)
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		for _, entry := range list {
+			k, v, ok := strings.Cut(entry, "=")
+			if ok {
+				mapping[k] = &v
+			} else {
+				mapping[k] = nil
+			}
+		}
+	case yaml.MappingNode:
+		// yaml.v3 can't decode scalars into *yaml.Node map values
+		var raw map[string]yaml.Node
+		if err := node.Decode(&raw); err != nil {
+			return err
+		}
+		for k, v := range raw {
+			if v.ShortTag() == "!!null" {
+				mapping[k] = nil
+				continue
+			}
+			value := v.Value
+			mapping[k] = &value
+		}
+	default:
+		return fmt.Errorf("line %d: expected a mapping or a list", node.Line)
+	}
+	*m = mapping
+	return nil
+}
+
+func (n *serviceNetworks) UnmarshalYAML(node *yaml.Node) error {
+	networks := make(serviceNetworks)
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		for _, name := range list {
+			networks[name] = new(serviceNetwork)
+		}
+	case yaml.MappingNode:
+		var raw map[string]*serviceNetwork
+		if err := node.Decode(&raw); err != nil {
+			return err
+		}
+		for name, network := range raw {
+			if network == nil {
+				network = new(serviceNetwork)
+			}
+			networks[name] = network
+		}
+	default:
+		return fmt.Errorf("line %d: expected a mapping or a list of networks", node.Line)
+	}
+	*n = networks
+	return nil
+}
+
+func (d *dependsOn) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		*d = list
+	case yaml.MappingNode:
+		var raw map[string]yaml.Node
+		if err := node.Decode(&raw); err != nil {
+			return err
+		}
+		// sorted, since the order of a mapping is lost, and the names are part of the configuration hash
+		*d = slices.Sorted(maps.Keys(raw))
+	default:
+		return fmt.Errorf("line %d: expected a mapping or a list of services", node.Line)
+	}
+	return nil
+}
+
+// splitCommand splits a command string into words the way a POSIX shell would, honoring quotes and escapes
+func splitCommand(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range command {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in command: %s", command)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
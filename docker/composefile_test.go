@@ -0,0 +1,405 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{"SET": "value", "EMPTY": ""}
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{name: "braces", content: "${SET}", want: "value"},
+		{name: "bare", content: "a-$SET-b", want: "a-value-b"},
+		{name: "unset", content: "[${UNSET}]", want: "[]"},
+		{name: "escaped", content: "$$SET", want: "$SET"},
+		{name: "default if unset or empty", content: "${EMPTY:-default} ${UNSET:-default}", want: "default default"},
+		{name: "default if unset", content: "${EMPTY-default} ${UNSET-default}", want: " default"},
+		{name: "default ignored if set", content: "${SET:-default}", want: "value"},
+		{name: "required and set", content: "${SET:?missing}", want: "value"},
+		{name: "required if unset or empty", content: "${EMPTY:?missing}", wantErr: true},
+		{name: "required if unset", content: "${EMPTY?missing}", want: ""},
+		{name: "required and unset", content: "${UNSET?missing}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := interpolate(tt.content, vars)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseComposeFile(t *testing.T) {
+	vars := map[string]string{"IMAGE": "redis:7", "REPLICAS": "3", "COMMAND": "a: b\nc", "EMPTY": ""}
+	tests := []struct {
+		name    string
+		content string
+		check   func(t *testing.T, service *composeService)
+		wantErr string
+	}{
+		{
+			name: "values",
+			content: `
+services:
+  redis:
+    image: ${IMAGE}
+`,
+			check: func(t *testing.T, service *composeService) {
+				if service.Image != "redis:7" {
+					t.Errorf("image %q", service.Image)
+				}
+			},
+		},
+		{
+			name: "comments are left as is",
+			content: `
+services:
+  # needs ${UNSET:?to be set}, once upgraded
+  redis:
+    image: redis # ${OTHER:?unset}
+`,
+			check: func(t *testing.T, service *composeService) {
+				if service.Image != "redis" {
+					t.Errorf("image %q", service.Image)
+				}
+			},
+		},
+		{
+			name: "values can't change the structure",
+			content: `
+services:
+  redis:
+    image: redis
+    command: echo ${COMMAND}
+`,
+			check: func(t *testing.T, service *composeService) {
+				if want := (commandSpec{"echo", "a:", "b", "c"}); !reflect.DeepEqual(service.Command, want) {
+					t.Errorf("command %q, want %q", service.Command, want)
+				}
+			},
+		},
+		{
+			name: "unquoted values are typed once substituted",
+			content: `
+services:
+  redis:
+    image: redis
+    deploy:
+      replicas: ${REPLICAS}
+`,
+			check: func(t *testing.T, service *composeService) {
+				if service.replicas() != 3 {
+					t.Errorf("replicas %d", service.replicas())
+				}
+			},
+		},
+		{
+			name: "missing required value",
+			content: `
+services:
+  redis:
+    image: ${EMPTY:?an image is required}
+`,
+			wantErr: "line 4: required variable EMPTY is missing a value: an image is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := parseComposeFile([]byte(tt.content), vars)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, file.Services["redis"])
+		})
+	}
+}
+
+func TestParseComposeFile_Empty(t *testing.T) {
+	file, err := parseComposeFile(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Services) != 0 {
+		t.Errorf("got services %v", file.Services)
+	}
+}
+
+func TestLoadComposeFiles_Merge(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write(".env", "TAG=5\n# comment\nNAME='from-dotenv'\n")
+	base := write("base.yml", `
+services:
+  redis:
+    image: redis:${TAG}
+    command: redis-server
+    environment:
+      A: "1"
+      B: "2"
+      NAME:
+    ports:
+      - "6379"
+    networks: [tests]
+  cache:
+    image: memcached
+networks:
+  tests:
+`)
+	override := write("override.yml", `
+services:
+  redis:
+    environment:
+      - B=3
+    ports:
+      - "6380"
+    networks:
+      other:
+        aliases: [db]
+`)
+	file, err := loadComposeFiles([]string{base, override}, []string{"TAG=6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redis := file.Services["redis"]
+	if redis.Image != "redis:6" {
+		t.Errorf("image %q: the given environment must override .env", redis.Image)
+	}
+	if want := []string{"A=1", "B=3", "NAME=from-dotenv"}; !reflect.DeepEqual(redis.env(), want) {
+		t.Errorf("environment %v, want %v", redis.env(), want)
+	}
+	if want := []string{"6379", "6380"}; !reflect.DeepEqual(redis.Ports, want) {
+		t.Errorf("ports %v, want %v", redis.Ports, want)
+	}
+	if want := []string{"other", "tests"}; !reflect.DeepEqual(redis.networkNames(), want) {
+		t.Errorf("networks %v, want %v", redis.networkNames(), want)
+	}
+	if want := (commandSpec{"redis-server"}); !reflect.DeepEqual(redis.Command, want) {
+		t.Errorf("command %v, want %v", redis.Command, want)
+	}
+	if file.Services["cache"] == nil || file.Networks["tests"] == nil {
+		t.Errorf("services %v and networks %v must be kept", file.Services, file.Networks)
+	}
+}
+
+func TestServiceOrder(t *testing.T) {
+	content := `
+services:
+  app:
+    image: app
+    depends_on: [db, cache]
+  worker:
+    image: worker
+    depends_on:
+      queue:
+        condition: service_started
+      db:
+        condition: service_healthy
+  db:
+    image: db
+  cache:
+    image: cache
+  queue:
+    image: queue
+    depends_on: [db]
+  loop-a:
+    image: a
+    depends_on: [loop-b]
+  loop-b:
+    image: b
+    depends_on: [loop-a]
+`
+	file, err := parseComposeFile([]byte(content), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (dependsOn{"db", "queue"}); !reflect.DeepEqual(file.Services["worker"].DependsOn, want) {
+		t.Errorf("depends_on %v, want %v", file.Services["worker"].DependsOn, want)
+	}
+	tests := []struct {
+		name     string
+		services []string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "dependencies first", services: []string{"app"}, want: []string{"cache", "db", "app"}},
+		{name: "transitive", services: []string{"worker"}, want: []string{"db", "queue", "worker"}},
+		{name: "shared dependencies once", services: []string{"worker", "app"}, want: []string{"cache", "db", "app", "queue", "worker"}},
+		{name: "cycle", services: []string{"loop-a"}, wantErr: true},
+		{name: "unknown", services: []string{"missing"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := file.serviceOrder(tt.services...)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerConfig_Ports(t *testing.T) {
+	tests := []struct {
+		name     string
+		ports    []string
+		expose   []string
+		exposed  []nat.Port
+		bindings map[nat.Port]string
+		wantErr  bool
+	}{
+		{name: "ephemeral host port", ports: []string{"6379"}, exposed: []nat.Port{"6379/tcp"}, bindings: map[nat.Port]string{"6379/tcp": ""}},
+		{name: "fixed host port", ports: []string{"16379:6379"}, exposed: []nat.Port{"6379/tcp"}, bindings: map[nat.Port]string{"6379/tcp": "16379"}},
+		{name: "protocol", ports: []string{"53:53/udp"}, exposed: []nat.Port{"53/udp"}, bindings: map[nat.Port]string{"53/udp": "53"}},
+		{name: "exposed only", expose: []string{"8080"}, exposed: []nat.Port{"8080/tcp"}},
+		{name: "invalid", ports: []string{"port"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &composeFile{Services: map[string]*composeService{
+				"svc": {Image: "image", Ports: tt.ports, Expose: tt.expose},
+			}}
+			backend := &engineBackend{compose: &Compose{project: "project"}}
+			config, hostConfig, err := backend.containerConfig(file, "svc", 1)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(config.ExposedPorts) != len(tt.exposed) {
+				t.Errorf("exposed %v, want %v", config.ExposedPorts, tt.exposed)
+			}
+			for _, port := range tt.exposed {
+				if _, ok := config.ExposedPorts[port]; !ok {
+					t.Errorf("port %s not exposed", port)
+				}
+			}
+			if len(hostConfig.PortBindings) != len(tt.bindings) {
+				t.Errorf("bindings %v, want %v", hostConfig.PortBindings, tt.bindings)
+			}
+			for port, hostPort := range tt.bindings {
+				if bindings := hostConfig.PortBindings[port]; len(bindings) != 1 || bindings[0].HostPort != hostPort {
+					t.Errorf("bindings of %s %v, want host port %q", port, bindings, hostPort)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMount(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &composeFile{
+		dir: "/project",
+		Volumes: map[string]*composeVolume{
+			"data":     {},
+			"named":    {Name: "explicit"},
+			"external": {External: true},
+		},
+	}
+	tests := []struct {
+		name    string
+		spec    string
+		want    mount.Mount
+		wantErr bool
+	}{
+		{name: "anonymous", spec: "/data", want: mount.Mount{Type: mount.TypeVolume, Target: "/data"}},
+		{name: "volume", spec: "data:/data", want: mount.Mount{Type: mount.TypeVolume, Source: "project_data", Target: "/data"}},
+		{name: "named volume", spec: "named:/data", want: mount.Mount{Type: mount.TypeVolume, Source: "explicit", Target: "/data"}},
+		{name: "external volume", spec: "external:/data", want: mount.Mount{Type: mount.TypeVolume, Source: "external", Target: "/data"}},
+		{name: "read-only", spec: "data:/data:ro", want: mount.Mount{Type: mount.TypeVolume, Source: "project_data", Target: "/data", ReadOnly: true}},
+		{name: "relative bind", spec: "./conf:/etc/conf:z,ro", want: mount.Mount{Type: mount.TypeBind, Source: "/project/conf", Target: "/etc/conf", ReadOnly: true}},
+		{name: "absolute bind", spec: "/host:/data", want: mount.Mount{Type: mount.TypeBind, Source: "/host", Target: "/data"}},
+		{name: "home bind", spec: "~/conf:/conf", want: mount.Mount{Type: mount.TypeBind, Source: filepath.Join(home, "conf"), Target: "/conf"}},
+		{name: "undeclared volume", spec: "undeclared:/data", wantErr: true},
+		{name: "too many parts", spec: "a:b:c:d", wantErr: true},
+	}
+	backend := &engineBackend{compose: &Compose{project: "project"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := backend.parseMount(file, tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{command: "redis-server --port 6379", want: []string{"redis-server", "--port", "6379"}},
+		{command: `sh -c "echo 'a b' && exit 1"`, want: []string{"sh", "-c", "echo 'a b' && exit 1"}},
+		{command: `echo 'a "b"' c\ d`, want: []string{"echo", `a "b"`, "c d"}},
+		{command: `echo ''`, want: []string{"echo", ""}},
+		{command: `echo "unterminated`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got, err := splitCommand(tt.command)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v", err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
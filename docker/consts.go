@@ -1,8 +1,16 @@
 package docker
 
 const (
-	ProjectID       = "tests"
+	ProjectID = "tests"
+	// DefaultLabel the label the service containers used to be required to carry.
+	//
+	// Deprecated: containers are found by their compose project and service labels. To also require a label, set
+	// EnvironmentConfig.Label
 	DefaultLabel    = "integration"
 	DefaultNetwork  = "tests"
 	EnvHostOverride = "HOST_OVERRIDE"
+	ProjectLabel    = "com.docker.compose.project"
+	ServiceLabel    = "com.docker.compose.service"
+	// ConfigHashLabel the label reused containers carry the hash of their effective configuration in
+	ConfigHashLabel = "io.github.keon94.go-compose.config-hash"
 )
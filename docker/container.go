@@ -15,14 +15,34 @@ import (
 type (
 	// Container wrapped API for docker containers
 	Container struct {
-		cli           *client.Client
+		cli *client.Client
+		// log the Logger of the container's environment
+		log Logger
+		// shaped the containers of the environment whose traffic is shaped, if any
+		shaped *shapedContainers
+		// sampling the environment's resource usage recorders, if any
+		sampling *statsRecorders
+		// tracker the project's container state view, if any
+		tracker       *stateTracker
 		Config        *container.Summary
 		ServiceConfig *ServiceConfig
 	}
 )
 
 func (c *Container) GetStatus() *ContainerStatus {
-	inspection, err := c.cli.ContainerInspect(context.Background(), c.Config.ID)
+	return c.GetStatusContext(context.Background())
+}
+
+// GetStatusContext like GetStatus, with a context for the Docker API call
+func (c *Container) GetStatusContext(ctx context.Context) *ContainerStatus {
+	if c.tracker != nil {
+		if state, ok := c.tracker.state(c.Config.ID); ok {
+			if status := state.status(); status != nil {
+				return status
+			}
+		}
+	}
+	inspection, err := c.cli.ContainerInspect(ctx, c.Config.ID)
 	if err != nil {
 		return &ContainerStatus{
 			Code:  Error,
@@ -70,7 +90,12 @@ func (c *Container) GetStatus() *ContainerStatus {
 }
 
 func (c *Container) Logs() (string, error) {
-	out, err := c.cli.ContainerLogs(context.Background(), c.Config.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	return c.LogsContext(context.Background())
+}
+
+// LogsContext like Logs, with a context for the Docker API call
+func (c *Container) LogsContext(ctx context.Context) (string, error) {
+	out, err := c.cli.ContainerLogs(ctx, c.Config.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
 	if err != nil {
 		return "", err
 	}
@@ -83,7 +108,12 @@ func (c *Container) Logs() (string, error) {
 }
 
 func (c *Container) State() (string, error) {
-	resp, err := c.cli.ContainerInspect(context.Background(), c.Config.ID)
+	return c.StateContext(context.Background())
+}
+
+// StateContext like State, with a context for the Docker API call
+func (c *Container) StateContext(ctx context.Context) (string, error) {
+	resp, err := c.cli.ContainerInspect(ctx, c.Config.ID)
 	if err != nil {
 		return "", err
 	}
@@ -96,7 +126,11 @@ func (c *Container) State() (string, error) {
 }
 
 func (c *Container) Exec(cmd string) ([]string, error) {
-	ctx := context.Background()
+	return c.ExecContext(context.Background(), cmd)
+}
+
+// ExecContext like Exec, with a context for the Docker API calls. Reading the output stops once ctx is done
+func (c *Container) ExecContext(ctx context.Context, cmd string) ([]string, error) {
 	resp, err := c.cli.ContainerExecCreate(ctx, c.Config.ID, container.ExecOptions{
 		Tty:          true,
 		AttachStdout: true,
@@ -112,6 +146,8 @@ func (c *Container) Exec(cmd string) ([]string, error) {
 		return nil, err
 	}
 	defer attach.Close()
+	stop := context.AfterFunc(ctx, attach.Close)
+	defer stop()
 	var lines []string
 	for {
 		bytes, _, err := attach.Reader.ReadLine()
@@ -120,12 +156,36 @@ func (c *Container) Exec(cmd string) ([]string, error) {
 		}
 		lines = append(lines, string(bytes))
 	}
+	if err = ctx.Err(); err != nil {
+		return lines, err
+	}
 	return lines, nil
 }
 
+// logger the Logger of the container's environment, or the global one
+func (c *Container) logger() Logger {
+	if c.log == nil {
+		return globalLogger()
+	}
+	return c.log
+}
+
 // GetEndpoints returns the public host, and map of private ports to list of public ports.
 func (c *Container) GetEndpoints() (Endpoints, error) {
+	mapping, err := c.endpoints()
+	if err != nil {
+		return nil, err
+	}
+	c.logger().Infof("container: %s is running on host: %s, port-bindings: %v", c.Config.Names[0], mapping.host, c.Config.Ports)
+	return mapping, nil
+}
+
+func (c *Container) endpoints() (*endpoints, error) {
 	network := c.Config.NetworkSettings.Networks[c.ServiceConfig.Network]
+	if network == nil {
+		// networks without an explicit name are prefixed with the project name by compose
+		network = c.Config.NetworkSettings.Networks[c.Config.Labels[ProjectLabel]+"_"+c.ServiceConfig.Network]
+	}
 	if network == nil {
 		return nil, fmt.Errorf("network not found for container %s", c.Config.Names[0])
 	}
@@ -142,10 +202,8 @@ func (c *Container) GetEndpoints() (Endpoints, error) {
 	} else if runtime.GOOS == "linux" && !isWSL() {
 		host = network.Gateway
 	}
-	logger.Printf("container: %s is running on host: %s, port-bindings: %v", c.Config.Names[0], host, c.Config.Ports)
-	mapping := endpoints{
+	return &endpoints{
 		host:  host,
 		ports: portMap,
-	}
-	return &mapping, nil
+	}, nil
 }
@@ -0,0 +1,267 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+type (
+	// CopyOptions how files are copied into or out of a container
+	CopyOptions struct {
+		// Mode the permissions of content copied from an io.Reader. Defaults to 0644. Files copied from a path keep
+		// their permissions
+		Mode os.FileMode
+		// PreserveOwnership keeps the uid/gid of the copied files (like "docker cp -a"). Otherwise, files copied into
+		// a container are owned by root, and files copied out of it by the current user
+		PreserveOwnership bool
+		// Owner optional owner to set on all the copied files. Takes precedence over PreserveOwnership
+		Owner *FileOwner
+		// AllowOverwriteDirWithFile allows replacing an existing directory in the container with a file
+		AllowOverwriteDirWithFile bool
+	}
+	// FileOwner numeric file ownership
+	FileOwner struct {
+		UID int
+		GID int
+	}
+)
+
+// CopyToContainer copies the file or directory at hostPath to containerPath, which is the path the copy ends up at
+// (not its parent directory). The parent directory must exist in the container
+func (c *Container) CopyToContainer(ctx context.Context, hostPath string, containerPath string, opts CopyOptions) error {
+	if _, err := os.Lstat(hostPath); err != nil {
+		return err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(writer, hostPath, path.Base(containerPath), opts))
+	}()
+	defer reader.Close()
+	return c.putArchive(ctx, reader, containerPath, opts)
+}
+
+// CopyReaderToContainer copies the content read from reader to the file at containerPath. The parent directory must
+// exist in the container
+func (c *Container) CopyReaderToContainer(ctx context.Context, reader io.Reader, containerPath string, opts CopyOptions) error {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	mode := opts.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Base(containerPath),
+		Mode:     int64(mode.Perm()),
+		Size:     int64(len(content)),
+		ModTime:  time.Now(),
+	}
+	setOwner(header, opts)
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err = tw.Write(content); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return c.putArchive(ctx, buf, containerPath, opts)
+}
+
+// CopyFromContainer copies the file or directory at containerPath to hostPath, which is the path the copy ends up at
+// (not its parent directory). Permissions are kept
+func (c *Container) CopyFromContainer(ctx context.Context, containerPath string, hostPath string, opts CopyOptions) error {
+	archive, _, err := c.cli.CopyFromContainer(ctx, c.Config.ID, containerPath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading %s from container %s: %w", containerPath, c.Config.Names[0], err)
+		}
+		target, err := archiveTarget(header.Name, hostPath)
+		if err != nil {
+			return err
+		}
+		if err = extractEntry(c.logger(), tr, header, hostPath, target, opts); err != nil {
+			return err
+		}
+	}
+}
+
+// CopyFileFromContainer writes the content of the file at containerPath to writer
+func (c *Container) CopyFileFromContainer(ctx context.Context, containerPath string, writer io.Writer) error {
+	archive, _, err := c.cli.CopyFromContainer(ctx, c.Config.ID, containerPath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	tr := tar.NewReader(archive)
+	header, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("error reading %s from container %s: %w", containerPath, c.Config.Names[0], err)
+	}
+	if header.Typeflag != tar.TypeReg {
+		return fmt.Errorf("%s in container %s is not a regular file", containerPath, c.Config.Names[0])
+	}
+	_, err = io.Copy(writer, tr)
+	return err
+}
+
+// putArchive extracts the tar archive, whose root entry is named after containerPath, in containerPath's directory
+func (c *Container) putArchive(ctx context.Context, archive io.Reader, containerPath string, opts CopyOptions) error {
+	err := c.cli.CopyToContainer(ctx, c.Config.ID, path.Dir(containerPath), archive, container.CopyToContainerOptions{
+		AllowOverwriteDirWithFile: opts.AllowOverwriteDirWithFile,
+		CopyUIDGID:                opts.PreserveOwnership || opts.Owner != nil,
+	})
+	if err != nil {
+		return fmt.Errorf("error copying to %s in container %s: %w", containerPath, c.Config.Names[0], err)
+	}
+	return nil
+}
+
+// writeTar archives the file or directory at hostPath, naming its root entry name
+func writeTar(writer io.Writer, hostPath string, name string, opts CopyOptions) error {
+	tw := tar.NewWriter(writer)
+	err := filepath.Walk(hostPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(hostPath, file)
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(name, filepath.ToSlash(rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.Uname, header.Gname = "", ""
+		setOwner(header, opts)
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// setOwner sets the archived ownership the options call for. Without PreserveOwnership, the daemon ignores it anyway
+func setOwner(header *tar.Header, opts CopyOptions) {
+	if opts.Owner != nil {
+		header.Uid, header.Gid = opts.Owner.UID, opts.Owner.GID
+	}
+}
+
+// archiveTarget maps an entry of an archive rooted at a single file or directory to its path under hostPath
+func archiveTarget(name string, hostPath string) (string, error) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	_, rel, _ := strings.Cut(name, "/")
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("archive entry %s escapes its root", name)
+	}
+	return filepath.Join(hostPath, filepath.FromSlash(rel)), nil
+}
+
+// checkLinkTarget rejects symbolic links that are absolute, or resolve outside of root
+func checkLinkTarget(linkname string, root string, target string) error {
+	if filepath.IsAbs(linkname) {
+		return fmt.Errorf("symbolic link %s has absolute target %s", target, linkname)
+	}
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Join(filepath.Dir(target), linkname))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("symbolic link %s has target %s outside of %s", target, linkname, root)
+	}
+	return nil
+}
+
+// extractEntry writes the archive entry to target, under root
+func extractEntry(log Logger, tr *tar.Reader, header *tar.Header, root string, target string, opts CopyOptions) error {
+	mode := header.FileInfo().Mode()
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, mode.Perm()); err != nil {
+			return err
+		}
+		// MkdirAll is subject to the umask, and leaves existing directories untouched
+		if err := os.Chmod(target, mode.Perm()); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if err = os.Chmod(target, mode.Perm()); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		// later entries could otherwise be written through the link, outside of root
+		if err := checkLinkTarget(header.Linkname, root, target); err != nil {
+			return err
+		}
+		_ = os.Remove(target)
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return err
+		}
+	default:
+		// devices, fifos and hard links aren't needed by tests
+		log.Warnf("skipping %s: unsupported archive entry type %q", header.Name, header.Typeflag)
+		return nil
+	}
+	if opts.Owner != nil {
+		return os.Lchown(target, opts.Owner.UID, opts.Owner.GID)
+	} else if opts.PreserveOwnership {
+		return os.Lchown(target, header.Uid, header.Gid)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    string
+		hostPath string
+		want     string
+		wantErr  bool
+	}{
+		{name: "root", entry: "conf", hostPath: "/tmp/out", want: "/tmp/out"},
+		{name: "root directory", entry: "conf/", hostPath: "/tmp/out", want: "/tmp/out"},
+		{name: "file", entry: "conf/app.yml", hostPath: "/tmp/out", want: "/tmp/out/app.yml"},
+		{name: "nested", entry: "conf/a/b/c.txt", hostPath: "/tmp/out", want: "/tmp/out/a/b/c.txt"},
+		{name: "leading slash", entry: "/conf/app.yml", hostPath: "/tmp/out", want: "/tmp/out/app.yml"},
+		{name: "cleaned", entry: "conf/a/../b", hostPath: "/tmp/out", want: "/tmp/out/b"},
+		{name: "parent", entry: "conf/..", hostPath: "/tmp/out", want: "/tmp/out"},
+		// the first element is the root, whatever its name
+		{name: "parent root", entry: "../etc/passwd", hostPath: "/tmp/out", want: "/tmp/out/etc/passwd"},
+		{name: "escaping", entry: "conf/../../../etc/passwd", hostPath: "/tmp/out", wantErr: true},
+		{name: "escaping directory", entry: "../..", hostPath: "/tmp/out", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := archiveTarget(tt.entry, tt.hostPath)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != filepath.FromSlash(tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLinkTarget(t *testing.T) {
+	root := "/tmp/out"
+	tests := []struct {
+		name     string
+		linkname string
+		target   string
+		wantErr  bool
+	}{
+		{name: "sibling", linkname: "app.yml", target: "/tmp/out/link"},
+		{name: "subdirectory", linkname: "a/b", target: "/tmp/out/link"},
+		{name: "parent within root", linkname: "../app.yml", target: "/tmp/out/a/link"},
+		{name: "root itself", linkname: "..", target: "/tmp/out/a/link"},
+		{name: "absolute", linkname: "/etc/passwd", target: "/tmp/out/link", wantErr: true},
+		{name: "escaping", linkname: "../etc/passwd", target: "/tmp/out/link", wantErr: true},
+		{name: "escaping from below", linkname: "../../../etc", target: "/tmp/out/a/link", wantErr: true},
+		{name: "sibling of root", linkname: "../out2/file", target: "/tmp/out/link", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLinkTarget(tt.linkname, root, tt.target)
+			if tt.wantErr != (err != nil) {
+				t.Errorf("got error %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
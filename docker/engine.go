@@ -0,0 +1,525 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	containerNumberLabel = "com.docker.compose.container-number"
+	oneoffLabel          = "com.docker.compose.oneoff"
+	networkLabel         = "com.docker.compose.network"
+	volumeLabel          = "com.docker.compose.volume"
+)
+
+type (
+	// engineBackend manages the compose project through the Docker Engine API, without a compose CLI
+	engineBackend struct {
+		compose *Compose
+	}
+
+	// EngineError an error returned by the Docker Engine API while managing a compose resource
+	EngineError struct {
+		// Op the operation that failed (e.g. "create", "start", "remove")
+		Op string
+		// Resource the kind of resource operated on ("container", "network", "volume" or "image")
+		Resource string
+		// Name the name of the resource
+		Name string
+		// Err the underlying error
+		Err error
+	}
+)
+
+func (e *EngineError) Error() string {
+	return fmt.Sprintf("could not %s %s %s: %v", e.Op, e.Resource, e.Name, e.Err)
+}
+
+func (e *EngineError) Unwrap() error {
+	return e.Err
+}
+
+func (b *engineBackend) up(ctx context.Context, services []string, renewVolumes bool) error {
+	file, err := loadComposeFiles(b.compose.config.Env.ComposeFilePaths, b.compose.getEnvVariables())
+	if err != nil {
+		return err
+	}
+	if err = file.requireImages(); err != nil {
+		return err
+	}
+	order, err := file.serviceOrder(services...)
+	if err != nil {
+		return err
+	}
+	for _, name := range order {
+		if err = b.upService(ctx, file, name, renewVolumes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *engineBackend) stop(ctx context.Context, services []string) error {
+	for _, service := range services {
+		list, err := b.listContainers(ctx, service)
+		if err != nil {
+			return err
+		}
+		for _, cntr := range list {
+			if err = b.removeContainer(ctx, cntr, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *engineBackend) down(ctx context.Context) error {
+	list, err := b.listContainers(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, cntr := range list {
+		if err = b.removeContainer(ctx, cntr, true); err != nil {
+			return err
+		}
+	}
+	projectFilter := filters.NewArgs(filters.Arg("label", ProjectLabel+"="+b.compose.project))
+	networks, err := b.compose.cli.NetworkList(ctx, network.ListOptions{Filters: projectFilter})
+	if err != nil {
+		return &EngineError{Op: "list", Resource: "network", Name: b.compose.project, Err: err}
+	}
+	for _, n := range networks {
+		if err = b.compose.cli.NetworkRemove(ctx, n.ID); err != nil && !client.IsErrNotFound(err) {
+			return &EngineError{Op: "remove", Resource: "network", Name: n.Name, Err: err}
+		}
+	}
+	volumes, err := b.compose.cli.VolumeList(ctx, volume.ListOptions{Filters: projectFilter})
+	if err != nil {
+		return &EngineError{Op: "list", Resource: "volume", Name: b.compose.project, Err: err}
+	}
+	for _, v := range volumes.Volumes {
+		if err = b.compose.cli.VolumeRemove(ctx, v.Name, true); err != nil && !client.IsErrNotFound(err) {
+			return &EngineError{Op: "remove", Resource: "volume", Name: v.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+// upService brings up a single service, creating its networks, volumes and image as needed. Existing containers are
+// kept (and started) unless renewVolumes is set, in which case they are recreated
+func (b *engineBackend) upService(ctx context.Context, file *composeFile, name string, renewVolumes bool) error {
+	service := file.Services[name]
+	networks, err := b.ensureNetworks(ctx, file, service)
+	if err != nil {
+		return err
+	}
+	if err = b.ensureVolumes(ctx, file, service); err != nil {
+		return err
+	}
+	if err = b.ensureImage(ctx, service.Image); err != nil {
+		return err
+	}
+	existing, err := b.listContainers(ctx, name)
+	if err != nil {
+		return err
+	}
+	if renewVolumes {
+		for _, cntr := range existing {
+			if err = b.removeContainer(ctx, cntr, true); err != nil {
+				return err
+			}
+		}
+		existing = nil
+	}
+	for _, cntr := range existing {
+		if cntr.State != "running" {
+			if err = b.compose.cli.ContainerStart(ctx, cntr.ID, container.StartOptions{}); err != nil {
+				return &EngineError{Op: "start", Resource: "container", Name: containerName(cntr), Err: err}
+			}
+		}
+	}
+	replicas := service.replicas()
+	if cfg := b.compose.config.Services[name]; cfg != nil && cfg.Replicas > 0 {
+		replicas = cfg.Replicas
+	}
+	sort.Slice(existing, func(i, j int) bool {
+		return containerNumber(existing[i]) < containerNumber(existing[j])
+	})
+	for len(existing) > replicas {
+		// scale down, removing the highest numbered containers first
+		if err = b.removeContainer(ctx, existing[len(existing)-1], false); err != nil {
+			return err
+		}
+		existing = existing[:len(existing)-1]
+	}
+	used := make(map[int]bool)
+	for _, cntr := range existing {
+		used[containerNumber(cntr)] = true
+	}
+	for number, missing := 1, replicas-len(existing); missing > 0; number++ {
+		if used[number] {
+			continue
+		}
+		if err = b.createContainer(ctx, file, name, number, networks); err != nil {
+			return err
+		}
+		missing--
+	}
+	return nil
+}
+
+func (b *engineBackend) createContainer(ctx context.Context, file *composeFile, name string, number int, networks map[string]string) error {
+	service := file.Services[name]
+	containerName := service.ContainerName
+	if containerName == "" {
+		containerName = fmt.Sprintf("%s-%s-%d", b.compose.project, name, number)
+	} else if number > 1 {
+		return fmt.Errorf("service %s sets container_name and can't have more than one container", name)
+	}
+	config, hostConfig, err := b.containerConfig(file, name, number)
+	if err != nil {
+		return fmt.Errorf("invalid configuration for service %s: %w", name, err)
+	}
+	endpoints := make(map[string]*network.EndpointSettings)
+	for _, key := range service.networkNames() {
+		settings := &network.EndpointSettings{Aliases: []string{name, containerName}}
+		if n := service.Networks[key]; n != nil {
+			settings.Aliases = append(settings.Aliases, n.Aliases...)
+			if n.IPv4Address != "" {
+				settings.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: n.IPv4Address}
+			}
+		}
+		endpoints[networks[key]] = settings
+	}
+	// the first network is attached at creation, the rest are connected before starting for older API versions
+	primary := networks[service.networkNames()[0]]
+	hostConfig.NetworkMode = container.NetworkMode(primary)
+	created, err := b.compose.cli.ContainerCreate(ctx, config, hostConfig, &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{primary: endpoints[primary]},
+	}, nil, containerName)
+	if err != nil {
+		return &EngineError{Op: "create", Resource: "container", Name: containerName, Err: err}
+	}
+	for networkName, settings := range endpoints {
+		if networkName == primary {
+			continue
+		}
+		if err = b.compose.cli.NetworkConnect(ctx, networkName, created.ID, settings); err != nil {
+			return &EngineError{Op: "connect", Resource: "network", Name: networkName, Err: err}
+		}
+	}
+	if err = b.compose.cli.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return &EngineError{Op: "start", Resource: "container", Name: containerName, Err: err}
+	}
+	return nil
+}
+
+func (b *engineBackend) containerConfig(file *composeFile, name string, number int) (*container.Config, *container.HostConfig, error) {
+	service := file.Services[name]
+	labels := service.Labels.values()
+	labels[ProjectLabel] = b.compose.project
+	labels[ServiceLabel] = name
+	labels[containerNumberLabel] = strconv.Itoa(number)
+	labels[oneoffLabel] = "False"
+	if hash, ok := b.compose.configHash(name); ok {
+		labels[ConfigHashLabel] = hash
+	}
+	exposed, bindings, err := nat.ParsePortSpecs(service.Ports)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, expose := range service.Expose {
+		proto, port := nat.SplitProtoPort(expose)
+		p, err := nat.NewPort(proto, port)
+		if err != nil {
+			return nil, nil, err
+		}
+		exposed[p] = struct{}{}
+	}
+	config := &container.Config{
+		Image:        service.Image,
+		Cmd:          []string(service.Command),
+		Entrypoint:   []string(service.Entrypoint),
+		Env:          service.env(),
+		Labels:       labels,
+		ExposedPorts: exposed,
+		WorkingDir:   service.WorkingDir,
+		User:         service.User,
+		Hostname:     service.Hostname,
+		StopSignal:   service.StopSignal,
+	}
+	if service.StopGracePeriod != "" {
+		grace, err := time.ParseDuration(service.StopGracePeriod)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid stop_grace_period: %w", err)
+		}
+		seconds := stopSeconds(grace)
+		config.StopTimeout = &seconds
+	}
+	if config.Healthcheck, err = healthConfig(service.Healthcheck); err != nil {
+		return nil, nil, err
+	}
+	hostConfig := &container.HostConfig{
+		PortBindings: bindings,
+		Privileged:   service.Privileged,
+		CapAdd:       service.CapAdd,
+		CapDrop:      service.CapDrop,
+		ExtraHosts:   service.ExtraHosts,
+		Sysctls:      service.Sysctls.values(),
+	}
+	if hostConfig.RestartPolicy, err = restartPolicy(service.Restart); err != nil {
+		return nil, nil, err
+	}
+	if len(service.Tmpfs) > 0 {
+		hostConfig.Tmpfs = make(map[string]string)
+		for _, tmpfs := range service.Tmpfs {
+			target, options, _ := strings.Cut(tmpfs, ":")
+			hostConfig.Tmpfs[target] = options
+		}
+	}
+	for _, spec := range service.Volumes {
+		m, err := b.parseMount(file, spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		hostConfig.Mounts = append(hostConfig.Mounts, m)
+	}
+	return config, hostConfig, nil
+}
+
+// parseMount parses the short volume syntax: [source:]target[:mode]
+func (b *engineBackend) parseMount(file *composeFile, spec string) (mount.Mount, error) {
+	parts := strings.Split(spec, ":")
+	m := mount.Mount{Type: mount.TypeVolume}
+	switch len(parts) {
+	case 1:
+		m.Target = parts[0]
+		return m, nil
+	case 2:
+		m.Source, m.Target = parts[0], parts[1]
+	case 3:
+		m.Source, m.Target = parts[0], parts[1]
+		for _, mode := range strings.Split(parts[2], ",") {
+			if mode == "ro" {
+				m.ReadOnly = true
+			}
+		}
+	default:
+		return m, fmt.Errorf("invalid volume spec %s", spec)
+	}
+	if strings.HasPrefix(m.Source, ".") || strings.HasPrefix(m.Source, "/") || strings.HasPrefix(m.Source, "~") {
+		m.Type = mount.TypeBind
+		if strings.HasPrefix(m.Source, "~") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return m, err
+			}
+			m.Source = filepath.Join(home, m.Source[1:])
+		} else if !filepath.IsAbs(m.Source) {
+			m.Source = filepath.Join(file.dir, m.Source)
+		}
+		return m, nil
+	}
+	v, ok := file.Volumes[m.Source]
+	if !ok {
+		return m, fmt.Errorf("volume %s is not declared in the top-level volumes", m.Source)
+	}
+	m.Source = b.volumeName(m.Source, v)
+	return m, nil
+}
+
+// ensureNetworks creates the service's networks that don't exist yet, and returns their keys mapped to their names
+func (b *engineBackend) ensureNetworks(ctx context.Context, file *composeFile, service *composeService) (map[string]string, error) {
+	names := make(map[string]string)
+	for _, key := range service.networkNames() {
+		n, ok := file.Networks[key]
+		if !ok {
+			if key != "default" {
+				return nil, fmt.Errorf("network %s is not declared in the top-level networks", key)
+			}
+			n = new(composeNetwork)
+		}
+		name := b.networkName(key, n)
+		names[key] = name
+		_, err := b.compose.cli.NetworkInspect(ctx, name, network.InspectOptions{})
+		if err == nil {
+			continue
+		} else if !client.IsErrNotFound(err) {
+			return nil, &EngineError{Op: "inspect", Resource: "network", Name: name, Err: err}
+		} else if n.External {
+			return nil, &EngineError{Op: "find", Resource: "external network", Name: name, Err: err}
+		}
+		labels := n.Labels.values()
+		labels[ProjectLabel] = b.compose.project
+		labels[networkLabel] = key
+		_, err = b.compose.cli.NetworkCreate(ctx, name, network.CreateOptions{
+			Driver:   n.Driver,
+			Internal: n.Internal,
+			Labels:   labels,
+		})
+		if err != nil {
+			return nil, &EngineError{Op: "create", Resource: "network", Name: name, Err: err}
+		}
+	}
+	return names, nil
+}
+
+// ensureVolumes creates the named volumes used by the service that don't exist yet
+func (b *engineBackend) ensureVolumes(ctx context.Context, file *composeFile, service *composeService) error {
+	for _, spec := range service.Volumes {
+		source, _, ok := strings.Cut(spec, ":")
+		v, declared := file.Volumes[source]
+		if !ok || !declared {
+			continue
+		}
+		name := b.volumeName(source, v)
+		_, err := b.compose.cli.VolumeInspect(ctx, name)
+		if err == nil {
+			continue
+		} else if !client.IsErrNotFound(err) {
+			return &EngineError{Op: "inspect", Resource: "volume", Name: name, Err: err}
+		} else if v.External {
+			return &EngineError{Op: "find", Resource: "external volume", Name: name, Err: err}
+		}
+		labels := v.Labels.values()
+		labels[ProjectLabel] = b.compose.project
+		labels[volumeLabel] = source
+		_, err = b.compose.cli.VolumeCreate(ctx, volume.CreateOptions{
+			Name:   name,
+			Driver: v.Driver,
+			Labels: labels,
+		})
+		if err != nil {
+			return &EngineError{Op: "create", Resource: "volume", Name: name, Err: err}
+		}
+	}
+	return nil
+}
+
+// ensureImage pulls the image if it isn't available locally
+func (b *engineBackend) ensureImage(ctx context.Context, ref string) error {
+	_, err := b.compose.cli.ImageInspect(ctx, ref)
+	if err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return &EngineError{Op: "inspect", Resource: "image", Name: ref, Err: err}
+	}
+	b.compose.log.Infof("pulling image %s", ref)
+	progress, err := b.compose.cli.ImagePull(ctx, ref, image.PullOptions{})
+	if err != nil {
+		return &EngineError{Op: "pull", Resource: "image", Name: ref, Err: err}
+	}
+	defer progress.Close()
+	if _, err = io.Copy(io.Discard, progress); err != nil {
+		return &EngineError{Op: "pull", Resource: "image", Name: ref, Err: err}
+	}
+	return nil
+}
+
+// listContainers lists the project's containers, for the given service only if not empty
+func (b *engineBackend) listContainers(ctx context.Context, service string) ([]container.Summary, error) {
+	args := filters.NewArgs(filters.Arg("label", ProjectLabel+"="+b.compose.project))
+	if service != "" {
+		args.Add("label", ServiceLabel+"="+service)
+	}
+	list, err := b.compose.cli.ContainerList(ctx, container.ListOptions{All: true, Filters: args})
+	if err != nil {
+		return nil, &EngineError{Op: "list", Resource: "container", Name: b.compose.project, Err: err}
+	}
+	return list, nil
+}
+
+// removeContainer gracefully stops, then removes the container
+func (b *engineBackend) removeContainer(ctx context.Context, cntr container.Summary, removeVolumes bool) error {
+	if err := b.compose.cli.ContainerStop(ctx, cntr.ID, container.StopOptions{}); err != nil && !client.IsErrNotFound(err) {
+		return &EngineError{Op: "stop", Resource: "container", Name: containerName(cntr), Err: err}
+	}
+	err := b.compose.cli.ContainerRemove(ctx, cntr.ID, container.RemoveOptions{RemoveVolumes: removeVolumes, Force: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		return &EngineError{Op: "remove", Resource: "container", Name: containerName(cntr), Err: err}
+	}
+	return nil
+}
+
+func (b *engineBackend) networkName(key string, n *composeNetwork) string {
+	if n.Name != "" {
+		return n.Name
+	} else if n.External {
+		return key
+	}
+	return b.compose.project + "_" + key
+}
+
+func (b *engineBackend) volumeName(key string, v *composeVolume) string {
+	if v.Name != "" {
+		return v.Name
+	} else if v.External {
+		return key
+	}
+	return b.compose.project + "_" + key
+}
+
+func healthConfig(health *composeHealth) (*container.HealthConfig, error) {
+	if health == nil {
+		return nil, nil
+	}
+	if health.Disable {
+		return &container.HealthConfig{Test: []string{"NONE"}}, nil
+	}
+	interval, timeout, startPeriod, err := health.durations()
+	if err != nil {
+		return nil, fmt.Errorf("invalid healthcheck duration: %w", err)
+	}
+	test := []string(health.Test)
+	if len(test) == 1 && test[0] != "NONE" {
+		// the string form is run by the shell
+		test = []string{"CMD-SHELL", test[0]}
+	}
+	return &container.HealthConfig{
+		Test:        test,
+		Interval:    interval,
+		Timeout:     timeout,
+		StartPeriod: startPeriod,
+		Retries:     health.Retries,
+	}, nil
+}
+
+func restartPolicy(restart string) (container.RestartPolicy, error) {
+	if restart == "" {
+		return container.RestartPolicy{}, nil
+	}
+	mode, count, _ := strings.Cut(restart, ":")
+	policy := container.RestartPolicy{Name: container.RestartPolicyMode(mode)}
+	if count != "" {
+		retries, err := strconv.Atoi(count)
+		if err != nil {
+			return policy, fmt.Errorf("invalid restart policy %s", restart)
+		}
+		policy.MaximumRetryCount = retries
+	}
+	return policy, nil
+}
+
+func containerName(cntr container.Summary) string {
+	if len(cntr.Names) == 0 {
+		return cntr.ID
+	}
+	return strings.TrimPrefix(cntr.Names[0], "/")
+}
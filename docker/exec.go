@@ -0,0 +1,143 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+type (
+	// ExecOptions a command to run inside a container, and how to run it
+	ExecOptions struct {
+		// Cmd the command in argv form. It is run without a shell, so it works on images that have none (e.g.
+		// distroless)
+		Cmd []string
+		// Shell optional command line to run with "sh -c" instead of Cmd
+		Shell string
+		// Stdin optional input to pipe to the command
+		Stdin io.Reader
+		// Env optional environment variables for the command
+		Env map[string]string
+		// User optional user (name or uid[:gid]) to run the command as
+		User string
+		// WorkingDir optional working directory of the command
+		WorkingDir string
+		// Tty allocates a pseudo-TTY. The command's stderr is then merged into ExecResult.Stdout
+		Tty bool
+		// Privileged runs the command with extended privileges (e.g. for network administration)
+		Privileged bool
+	}
+	// ExecResult the outcome of a command that ran inside a container
+	ExecResult struct {
+		ExitCode int
+		Stdout   string
+		Stderr   string
+	}
+	// ExecError returned by ExecOrFail when the command exits with a non-zero code
+	ExecError struct {
+		Cmd    []string
+		Result *ExecResult
+	}
+)
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("command %q exited with code %d. stderr: %s", e.Cmd, e.Result.ExitCode, e.Result.Stderr)
+}
+
+// ExecWithOptions runs the command inside the container and returns its exit code and output. A non-zero exit code is
+// not an error, see ExecOrFail
+func (c *Container) ExecWithOptions(ctx context.Context, opts ExecOptions) (*ExecResult, error) {
+	cmd := opts.Cmd
+	if opts.Shell != "" {
+		cmd = []string{"sh", "-c", opts.Shell}
+	}
+	if len(cmd) == 0 {
+		return nil, errors.New("no command to exec")
+	}
+	var env []string
+	for k, v := range opts.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	resp, err := c.cli.ContainerExecCreate(ctx, c.Config.ID, container.ExecOptions{
+		Cmd:          cmd,
+		Env:          env,
+		User:         opts.User,
+		WorkingDir:   opts.WorkingDir,
+		Tty:          opts.Tty,
+		Privileged:   opts.Privileged,
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	attach, err := c.cli.ContainerExecAttach(ctx, resp.ID, container.ExecAttachOptions{Tty: opts.Tty})
+	if err != nil {
+		return nil, err
+	}
+	defer attach.Close()
+	stop := context.AfterFunc(ctx, attach.Close)
+	defer stop()
+	if opts.Stdin != nil {
+		go func() {
+			_, _ = io.Copy(attach.Conn, opts.Stdin)
+			_ = attach.CloseWrite()
+		}()
+	}
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	if opts.Tty {
+		_, err = io.Copy(stdout, attach.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, attach.Reader)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading output of command %q: %w", cmd, err)
+	}
+	// the output ends slightly before the exec is reported as done
+	for {
+		inspection, err := c.cli.ContainerExecInspect(ctx, resp.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !inspection.Running {
+			return &ExecResult{
+				ExitCode: inspection.ExitCode,
+				Stdout:   stdout.String(),
+				Stderr:   stderr.String(),
+			}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// ExecOrFail like ExecWithOptions, but returns an *ExecError (along with the result) if the command exits with a
+// non-zero code
+func (c *Container) ExecOrFail(ctx context.Context, opts ExecOptions) (*ExecResult, error) {
+	result, err := c.ExecWithOptions(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		cmd := opts.Cmd
+		if opts.Shell != "" {
+			cmd = []string{"sh", "-c", opts.Shell}
+		}
+		return result, &ExecError{Cmd: cmd, Result: result}
+	}
+	return result, nil
+}
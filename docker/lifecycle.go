@@ -0,0 +1,138 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Pause freezes all processes of the container, keeping its state (see docker pause). Unpause resumes them
+func (c *Container) Pause() error {
+	return c.PauseContext(context.Background())
+}
+
+// PauseContext like Pause, with a context for the Docker API call
+func (c *Container) PauseContext(ctx context.Context) error {
+	if err := c.cli.ContainerPause(ctx, c.Config.ID); err != nil {
+		return &EngineError{Op: "pause", Resource: "container", Name: c.Config.Names[0], Err: err}
+	}
+	return nil
+}
+
+// Unpause resumes the processes frozen by Pause
+func (c *Container) Unpause() error {
+	return c.UnpauseContext(context.Background())
+}
+
+// UnpauseContext like Unpause, with a context for the Docker API call
+func (c *Container) UnpauseContext(ctx context.Context) error {
+	if err := c.cli.ContainerUnpause(ctx, c.Config.ID); err != nil {
+		return &EngineError{Op: "unpause", Resource: "container", Name: c.Config.Names[0], Err: err}
+	}
+	return nil
+}
+
+// Restart stops the container, killing it if it hasn't exited within the timeout, then starts it again. Unlike
+// Environment.StopServices/StartServices, the same container (and its filesystem) is kept. Use
+// Environment.RestartServices to also wait for the service to be ready again
+func (c *Container) Restart(timeout time.Duration) error {
+	return c.RestartContext(context.Background(), timeout)
+}
+
+// RestartContext like Restart, with a context for the Docker API call
+func (c *Container) RestartContext(ctx context.Context, timeout time.Duration) error {
+	seconds := stopSeconds(timeout)
+	if err := c.cli.ContainerRestart(ctx, c.Config.ID, container.StopOptions{Timeout: &seconds}); err != nil {
+		return &EngineError{Op: "restart", Resource: "container", Name: c.Config.Names[0], Err: err}
+	}
+	return nil
+}
+
+// stopSeconds the whole seconds the Docker API takes as stop timeout, rounded up: 0 kills right away
+func stopSeconds(timeout time.Duration) int {
+	return int(math.Ceil(timeout.Seconds()))
+}
+
+// Kill sends the signal (e.g. "SIGTERM", "SIGKILL" or "HUP") to the container's main process. Defaults to SIGKILL
+func (c *Container) Kill(signal string) error {
+	return c.KillContext(context.Background(), signal)
+}
+
+// KillContext like Kill, with a context for the Docker API call
+func (c *Container) KillContext(ctx context.Context, signal string) error {
+	if err := c.cli.ContainerKill(ctx, c.Config.ID, signal); err != nil {
+		return &EngineError{Op: "kill", Resource: "container", Name: c.Config.Names[0], Err: err}
+	}
+	return nil
+}
+
+// RestartServices restarts the containers of the services in place (see Container.Restart), giving them timeout to
+// stop. It then waits for them to be running (and ready, see ServiceEntry.WaitFor), and runs their handlers again,
+// updating Services. Their logs keep being streamed, if EnvironmentConfig.StreamLogs is set
+func (e *Environment) RestartServices(timeout time.Duration, services ...string) error {
+	return e.RestartServicesContext(context.Background(), timeout, services...)
+}
+
+// RestartServicesContext like RestartServices, but stops waiting for the services once ctx is done
+func (e *Environment) RestartServicesContext(ctx context.Context, timeout time.Duration, services ...string) error {
+	ctx, cancel := registry.withInterrupt(ctx)
+	defer cancel()
+	configs := e.compose.getServiceConfigs(services...)
+	if len(configs) != len(services) {
+		return fmt.Errorf("can't restart unmanaged service contained in: %v", services)
+	}
+	tracker := e.compose.stateTracker()
+	var entries []*ServiceEntry
+	for _, service := range services {
+		containers, err := e.compose.GetContainersContext(ctx, service)
+		if err != nil {
+			return err
+		}
+		if len(containers) == 0 {
+			return fmt.Errorf("no container found for service %s", service)
+		}
+		for _, cntr := range containers {
+			// its log stream ends when it stops, so follow it again from now on
+			since := time.Now()
+			if e.logs != nil {
+				e.logs.unfollow(cntr)
+			}
+			err = cntr.RestartContext(ctx, timeout)
+			if e.logs != nil {
+				e.logs.follow(LogOptions{Since: since}, cntr)
+			}
+			if err != nil {
+				return err
+			}
+			// don't let the awaiting read the state from before the restart (e.g. healthy), whose events may still
+			// be on their way
+			if err = tracker.refresh(ctx, cntr.Config.ID); err != nil {
+				return err
+			}
+		}
+		if entry := e.entries[service]; entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	upCtx, upCancel := context.WithTimeout(ctx, e.compose.config.Env.UpTimeout)
+	defer upCancel()
+	if err := awaitState(upCtx, configs, e.compose.awaitStart); err != nil {
+		return fmt.Errorf("error restarting services: %w", err)
+	}
+	e.compose.log.Infof("restarted services %v", services)
+	// the handlers replace the outputs, so keep those of the other services
+	previous := e.Services
+	if err := e.invokeServiceHandlers(ctx, entries...); err != nil {
+		e.Services = previous
+		return err
+	}
+	for name, output := range previous {
+		if _, ok := e.Services[name]; !ok {
+			e.Services[name] = output
+		}
+	}
+	return nil
+}
@@ -1,8 +1,14 @@
 package docker
 
-import "github.com/sirupsen/logrus"
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
 
-var logger = logrus.New()
+	"github.com/sirupsen/logrus"
+)
 
 type Color string
 
@@ -17,10 +23,169 @@ const (
 	ColorReset = Color("\033[0m")
 )
 
-func init() {
-	logger.SetFormatter(&logrus.TextFormatter{
-		ForceColors:   true,
+type (
+	// Logger receives all of the package's output. Set it globally with SetLogger, or per environment with
+	// EnvironmentConfig.Logger
+	Logger interface {
+		Infof(format string, args ...interface{})
+		Warnf(format string, args ...interface{})
+		Errorf(format string, args ...interface{})
+		// Print outputs text verbatim, e.g. the logs of a container. The color is only a hint
+		Print(color Color, text string)
+	}
+
+	// stdoutLogger the default Logger: logrus for messages, and stdout for verbatim text. Colors are only used if
+	// stdout is a terminal
+	stdoutLogger struct {
+		*logrus.Logger
+		colors bool
+		lock   sync.Mutex
+	}
+	logrusLogger struct {
+		logrus.FieldLogger
+	}
+	slogLogger struct {
+		logger *slog.Logger
+	}
+	testLogger struct {
+		tb   TB
+		lock sync.RWMutex
+		// done set once the test completed, after which the output goes to the global Logger
+		done bool
+	}
+	discardLogger struct{}
+)
+
+var (
+	logger     Logger = newStdoutLogger()
+	loggerLock sync.RWMutex
+)
+
+// SetLogger replaces the global Logger, used by the environments that don't set their own. nil restores the default one
+func SetLogger(l Logger) {
+	if l == nil {
+		l = newStdoutLogger()
+	}
+	loggerLock.Lock()
+	defer loggerLock.Unlock()
+	logger = l
+}
+
+// globalLogger the current global Logger
+func globalLogger() Logger {
+	loggerLock.RLock()
+	defer loggerLock.RUnlock()
+	return logger
+}
+
+// NewLogrusLogger a Logger writing everything to l. Verbatim text is logged at the info level, without colors
+func NewLogrusLogger(l logrus.FieldLogger) Logger {
+	return &logrusLogger{FieldLogger: l}
+}
+
+// NewSlogLogger a Logger writing everything to l. Verbatim text is logged at the info level, without colors
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{logger: l}
+}
+
+// NewTestLogger a Logger writing everything to the test's log. Once the test completed (e.g. for the goroutines still
+// streaming logs), the output goes to the global Logger instead
+func NewTestLogger(tb TB) Logger {
+	l := &testLogger{tb: tb}
+	tb.Cleanup(func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		l.done = true
+	})
+	return l
+}
+
+// DiscardLogger a Logger that drops everything
+func DiscardLogger() Logger {
+	return discardLogger{}
+}
+
+func newStdoutLogger() *stdoutLogger {
+	l := logrus.New()
+	// logrus colors its output only if it's a terminal, unless forced
+	l.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 		PadLevelText:  true,
 	})
+	return &stdoutLogger{Logger: l, colors: isTerminal(os.Stdout)}
+}
+
+func (l *stdoutLogger) Print(color Color, text string) {
+	if l.colors && color != "" {
+		text = string(color) + strings.ReplaceAll(text, "\n", "\n"+string(color)) + string(ColorReset)
+	}
+	// keep concurrent prints (e.g. streamed logs) from interleaving
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, _ = fmt.Fprintln(os.Stdout, text)
+}
+
+func (l *logrusLogger) Print(_ Color, text string) {
+	l.Info(text)
+}
+
+func (l *slogLogger) Infof(format string, args ...interface{}) {
+	l.logger.Info(fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Warnf(format string, args ...interface{}) {
+	l.logger.Warn(fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Error(fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Print(_ Color, text string) {
+	l.logger.Info(text)
+}
+
+func (l *testLogger) Infof(format string, args ...interface{}) {
+	l.logf("INFO", globalLogger().Infof, format, args...)
+}
+
+func (l *testLogger) Warnf(format string, args ...interface{}) {
+	l.logf("WARN", globalLogger().Warnf, format, args...)
+}
+
+func (l *testLogger) Errorf(format string, args ...interface{}) {
+	l.logf("ERROR", globalLogger().Errorf, format, args...)
+}
+
+func (l *testLogger) Print(color Color, text string) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.done {
+		globalLogger().Print(color, text)
+		return
+	}
+	l.tb.Log(text)
+}
+
+// logf logs through the test, or through fallback once the test completed. The lock keeps the test from completing
+// mid-call
+func (l *testLogger) logf(level string, fallback func(format string, args ...interface{}), format string, args ...interface{}) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.done {
+		fallback(format, args...)
+		return
+	}
+	l.tb.Logf(level+" "+format, args...)
+}
+
+func (discardLogger) Infof(string, ...interface{})  {}
+func (discardLogger) Warnf(string, ...interface{})  {}
+func (discardLogger) Errorf(string, ...interface{}) {}
+func (discardLogger) Print(Color, string)           {}
+
+// isTerminal whether the file is a terminal (rather than e.g. a pipe to a CI log collector)
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,295 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+const (
+	// Stdout the standard output stream of a container
+	Stdout LogStream = "stdout"
+	// Stderr the standard error stream of a container
+	Stderr LogStream = "stderr"
+)
+
+// logTailSize how many of the most recent log lines a LogWaitError carries
+const logTailSize = 20
+
+// serviceColors the colors the services' streamed logs are prefixed with, in turn
+var serviceColors = []Color{CYAN, MAGENTA, BLUE, YELLOW, GREEN, RED}
+
+type (
+	// LogStream identifies a container output stream
+	LogStream string
+
+	// LogOptions selects the log lines to stream
+	LogOptions struct {
+		// Since optionally skips the lines logged before this time
+		Since time.Time
+		// Tail optionally limits the existing lines to this many of the most recent ones. New lines are all streamed
+		Tail int
+		// Stream optionally restricts the lines to a single stream (Stdout or Stderr)
+		Stream LogStream
+	}
+
+	// LogLine a line of a container's logs
+	LogLine struct {
+		// Container the name of the container that logged the line
+		Container string
+		// Stream the stream the line was logged to. Containers with a TTY only have Stdout
+		Stream LogStream
+		// Timestamp when the line was logged
+		Timestamp time.Time
+		// Text the line, without its line ending
+		Text string
+	}
+
+	// LogWaitError returned by WaitForLog when the pattern wasn't matched enough times
+	LogWaitError struct {
+		Container   string
+		Pattern     *regexp.Regexp
+		Occurrences int
+		// Matched the lines that did match
+		Matched []LogLine
+		// Tail the most recent lines of the logs
+		Tail []LogLine
+		// Err why the wait ended: the context's error, or nil if the log stream ended (e.g. the container exited)
+		Err error
+	}
+
+	// logStreamer streams the logs of an environment's containers to stdout while the tests run
+	logStreamer struct {
+		ctx    context.Context
+		cancel context.CancelFunc
+		lock   sync.Mutex
+		// followed cancels the log stream of each container being streamed, by ID
+		followed map[string]*context.CancelFunc
+		log      Logger
+		// colors the color assigned to each service
+		colors map[string]Color
+	}
+)
+
+// FollowLogs streams the container's log lines, starting with the existing ones (see LogOptions), until ctx is done
+// or the container is removed. The channel is closed then
+func (c *Container) FollowLogs(ctx context.Context, opts LogOptions) (<-chan LogLine, error) {
+	return c.streamLogs(ctx, opts, true)
+}
+
+// WaitForLog blocks until occurrences lines of the container's logs (counting the existing ones) match pattern, and
+// returns them. It follows the log stream rather than re-reading the logs. If ctx is done or the stream ends first, a
+// *LogWaitError is returned
+func (c *Container) WaitForLog(ctx context.Context, pattern *regexp.Regexp, occurrences int) ([]LogLine, error) {
+	if occurrences < 1 {
+		occurrences = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lines, err := c.FollowLogs(ctx, LogOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var matched, tail []LogLine
+	for line := range lines {
+		if pattern.MatchString(line.Text) {
+			matched = append(matched, line)
+			if len(matched) == occurrences {
+				return matched, nil
+			}
+		}
+		if len(tail) == logTailSize {
+			tail = tail[1:]
+		}
+		tail = append(tail, line)
+	}
+	return matched, &LogWaitError{
+		Container:   strings.TrimPrefix(c.Config.Names[0], "/"),
+		Pattern:     pattern,
+		Occurrences: occurrences,
+		Matched:     matched,
+		Tail:        tail,
+		Err:         ctx.Err(),
+	}
+}
+
+func (e *LogWaitError) Error() string {
+	reason := "log stream ended"
+	if e.Err != nil {
+		reason = e.Err.Error()
+	}
+	text := make([]string, len(e.Tail))
+	for i, line := range e.Tail {
+		text[i] = line.Text
+	}
+	return fmt.Sprintf("%s: found %d of %d log lines of container %s matching %s. last lines:\n%s",
+		reason, len(e.Matched), e.Occurrences, e.Container, e.Pattern, strings.Join(text, "\n"))
+}
+
+func (e *LogWaitError) Unwrap() error {
+	return e.Err
+}
+
+// streamLogs streams the log lines selected by opts. Unless follow is set, the stream ends after the existing lines
+func (c *Container) streamLogs(ctx context.Context, opts LogOptions, follow bool) (<-chan LogLine, error) {
+	inspection, err := c.cli.ContainerInspect(ctx, c.Config.ID)
+	if err != nil {
+		return nil, err
+	}
+	logsOptions := container.LogsOptions{
+		ShowStdout: opts.Stream != Stderr,
+		ShowStderr: opts.Stream != Stdout,
+		Follow:     follow,
+		Timestamps: true,
+	}
+	if !opts.Since.IsZero() {
+		logsOptions.Since = opts.Since.Format(time.RFC3339Nano)
+	}
+	if opts.Tail > 0 {
+		logsOptions.Tail = strconv.Itoa(opts.Tail)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	body, err := c.cli.ContainerLogs(ctx, c.Config.ID, logsOptions)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	lines := make(chan LogLine, 64)
+	name := strings.TrimPrefix(c.Config.Names[0], "/")
+	readers := new(sync.WaitGroup)
+	scan := func(stream LogStream, reader io.Reader) {
+		defer readers.Done()
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := LogLine{Container: name, Stream: stream}
+			line.Timestamp, line.Text = parseTimestamp(scanner.Text())
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+		// unblock the demultiplexer if the scan was aborted
+		_, _ = io.Copy(io.Discard, reader)
+	}
+	if inspection.Config.Tty {
+		readers.Add(1)
+		go scan(Stdout, body)
+	} else {
+		stdoutReader, stdoutWriter := io.Pipe()
+		stderrReader, stderrWriter := io.Pipe()
+		readers.Add(2)
+		go scan(Stdout, stdoutReader)
+		go scan(Stderr, stderrReader)
+		go func() {
+			_, err := stdcopy.StdCopy(stdoutWriter, stderrWriter, body)
+			stdoutWriter.CloseWithError(err)
+			stderrWriter.CloseWithError(err)
+		}()
+	}
+	go func() {
+		readers.Wait()
+		cancel()
+		body.Close()
+		close(lines)
+	}()
+	// unblock the readers once ctx is done
+	context.AfterFunc(ctx, func() {
+		body.Close()
+	})
+	return lines, nil
+}
+
+// parseTimestamp splits the RFC3339 timestamp the daemon prefixes the line with from the line
+func parseTimestamp(line string) (time.Time, string) {
+	prefix, text, ok := strings.Cut(line, " ")
+	if !ok {
+		prefix, text = line, ""
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, prefix)
+	if err != nil {
+		return time.Time{}, line
+	}
+	return timestamp, text
+}
+
+func newLogStreamer(log Logger) *logStreamer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &logStreamer{
+		ctx:      ctx,
+		cancel:   cancel,
+		followed: make(map[string]*context.CancelFunc),
+		colors:   make(map[string]Color),
+		log:      log,
+	}
+}
+
+// follow starts streaming the log lines selected by opts of the containers that aren't streamed yet
+func (s *logStreamer) follow(opts LogOptions, containers ...*Container) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, cntr := range containers {
+		if s.followed[cntr.Config.ID] != nil {
+			continue
+		}
+		service := cntr.Config.Labels[ServiceLabel]
+		color, ok := s.colors[service]
+		if !ok {
+			color = serviceColors[len(s.colors)%len(serviceColors)]
+			s.colors[service] = color
+		}
+		prefix := service
+		if len(cntr.Config.Names) > 0 && cntr.Config.Labels[containerNumberLabel] != "1" {
+			prefix = strings.TrimPrefix(cntr.Config.Names[0], "/")
+		}
+		ctx, cancel := context.WithCancel(s.ctx)
+		lines, err := cntr.FollowLogs(ctx, opts)
+		if err != nil {
+			cancel()
+			s.log.Warnf("could not stream logs of service %s: %v", service, err)
+			continue
+		}
+		id := cntr.Config.ID
+		s.followed[id] = &cancel
+		go func() {
+			defer cancel()
+			for line := range lines {
+				s.log.Print(color, fmt.Sprintf("[%s] %s", prefix, line.Text))
+			}
+			s.lock.Lock()
+			// unless unfollowed, and followed again since
+			if s.followed[id] == &cancel {
+				delete(s.followed, id)
+			}
+			s.lock.Unlock()
+		}()
+	}
+}
+
+// unfollow stops streaming the logs of the containers, e.g. before restarting them, as their streams end once they
+// stop
+func (s *logStreamer) unfollow(containers ...*Container) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, cntr := range containers {
+		if cancel := s.followed[cntr.Config.ID]; cancel != nil {
+			(*cancel)()
+			delete(s.followed, cntr.Config.ID)
+		}
+	}
+}
+
+// stop stops all streaming
+func (s *logStreamer) stop() {
+	s.cancel()
+}
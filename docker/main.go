@@ -1,7 +1,9 @@
 package docker
 
 import (
+	"context"
 	"fmt"
+	"sync"
 )
 
 type (
@@ -12,6 +14,20 @@ type (
 		afterHandlers []AfterHandler
 		compose       *Compose
 		noShutdown    bool
+		// tb the test the environment is bound to, if started with StartEnvironmentT
+		tb TB
+		// logs streams the services' logs, if EnvironmentConfig.StreamLogs is set
+		logs *logStreamer
+		// reaper removes the project if the process dies before Shutdown, if EnvironmentConfig.Reap is set
+		reaper *reaper
+		// partitions the network partitions to undo on Heal or shutdown
+		partitions []*partition
+		// entries the entries of the services started, by name
+		entries map[string]*ServiceEntry
+		// shutdownLock serializes shutdowns, which may also be triggered by a signal (see HandleSignals)
+		shutdownLock sync.Mutex
+		// shutdown set once the environment was shut down, so that it's only done once
+		shutdown bool
 	}
 	ServiceEntry struct {
 		//Name see ServiceConfig.Name
@@ -21,6 +37,8 @@ type (
 		// Handler Function to extract relevant data from the service's container for the test's needs (optional, but
 		// usually needed by tests/consumers)
 		Handler ServiceHandler
+		// ClusterHandler like Handler, but receives all the replicas of a scaled service. Takes precedence over Handler
+		ClusterHandler ClusterHandler
 		// Before Function to run before container startup (optional)
 		Before BeforeHandler
 		// Before Function to run after container shutdown (optional)
@@ -29,43 +47,88 @@ type (
 		EnvironmentVars map[string]string
 		// Network optional network name, otherwise defaults to the Network const
 		Network string
+		// WaitFor optional readiness strategy that must be satisfied before the Handler runs, e.g.
+		// ForAll(ForListeningPort(6379), ForLog(regexp.MustCompile("Ready to accept connections")))
+		WaitFor WaitStrategy
+		// Replicas optional number of containers to scale the service to (see ServiceConfig.Replicas). Use a
+		// ClusterHandler to access all of them
+		Replicas int
 	}
 	BeforeHandler  func() error
 	ServiceHandler func(*Container) (interface{}, error)
+	ClusterHandler func([]*Container) (interface{}, error)
 	AfterHandler   func()
 )
 
 func StartEnvironment(config *EnvironmentConfig, entries ...*ServiceEntry) (*Environment, error) {
+	return StartEnvironmentContext(context.Background(), config, entries...)
+}
+
+// StartEnvironmentContext like StartEnvironment, but stops starting the environment (and cleans it up) once ctx is
+// done
+func StartEnvironmentContext(ctx context.Context, config *EnvironmentConfig, entries ...*ServiceEntry) (*Environment, error) {
+	return startEnvironment(ctx, nil, config, entries...)
+}
+
+// startEnvironment starts the environment. If tb is not nil, start-up errors are reported to it, and the shutdown
+// logs go through it instead of stdout (see StartEnvironmentT)
+func startEnvironment(ctx context.Context, tb TB, config *EnvironmentConfig, entries ...*ServiceEntry) (*Environment, error) {
+	ctx, cancel := registry.withInterrupt(ctx)
+	defer cancel()
 	serviceConfigs := getServiceConfigsMap(mapServiceEntries(entries...))
 	compose, err := NewCompose(ComposeConfig{
 		Env:      config,
 		Services: serviceConfigs,
 	})
 	if err != nil {
+		if tb != nil {
+			tb.Errorf("could not start environment: %v", err)
+		}
 		return nil, err
 	}
 	env := &Environment{
 		compose:    compose,
-		noShutdown: config.NoShutdown,
-	}
-	if !config.NoCleanup {
-		_ = env.compose.Down() //do this in case of a running state...
+		noShutdown: config.NoShutdown || config.Reuse,
+		tb:         tb,
 	}
+	// before the environment is registered anywhere, so that a failure only leaves the compose instance to close
 	err = env.setupServiceConfigs(entries...)
 	if err != nil {
-		return nil, err
-	}
-	err = env.compose.Up()
-	if err != nil {
-		if !config.NoShutdown {
-			env.Shutdown()
+		if tb != nil {
+			tb.Errorf("could not start environment: %v", err)
 		}
+		compose.Close()
 		return nil, err
 	}
-	err = env.invokeServiceHandlers(entries...)
+	if config.StreamLogs {
+		env.logs = newLogStreamer(compose.log)
+	}
+	if config.Reap && !env.noShutdown {
+		env.startReaping()
+	}
+	registry.add(env)
+	if !config.NoCleanup && !config.Reuse {
+		_ = env.compose.DownContext(ctx) //do this in case of a running state...
+	}
+	err = env.compose.UpContext(ctx)
+	env.followLogs(ctx, entries...)
+	if err == nil {
+		err = env.invokeServiceHandlers(ctx, entries...)
+	}
 	if err != nil {
-		if !config.NoShutdown {
+		if tb != nil {
+			// fail before shutting down, so that the diagnostics are dumped
+			tb.Errorf("could not start environment: %v", err)
+		}
+		if !env.noShutdown {
 			env.Shutdown()
+		} else {
+			env.captureArtifacts(context.Background())
+			registry.remove(env)
+			if env.logs != nil {
+				env.logs.stop()
+			}
+			compose.Close()
 		}
 		return nil, err
 	}
@@ -73,22 +136,30 @@ func StartEnvironment(config *EnvironmentConfig, entries ...*ServiceEntry) (*Env
 }
 
 func (e *Environment) StartServices(entries ...*ServiceEntry) error {
+	return e.StartServicesContext(context.Background(), entries...)
+}
+
+// StartServicesContext like StartServices, but stops starting the services (and stops them) once ctx is done
+func (e *Environment) StartServicesContext(ctx context.Context, entries ...*ServiceEntry) error {
+	ctx, cancel := registry.withInterrupt(ctx)
+	defer cancel()
 	err := e.setupServiceConfigs(entries...)
 	if err != nil {
 		return err
 	}
 	configs := getServiceConfigs(entries...)
-	err = e.compose.Start(configs...)
+	err = e.compose.StartContext(ctx, configs...)
+	e.followLogs(ctx, entries...)
 	if err != nil {
 		if stopErr := e.StopServices(getServiceNames(configs)...); stopErr != nil {
-			logger.Warnf("could not call stop successfuly: %v", stopErr)
+			e.compose.log.Warnf("could not call stop successfuly: %v", stopErr)
 		}
 		return err
 	}
-	err = e.invokeServiceHandlers(entries...)
+	err = e.invokeServiceHandlers(ctx, entries...)
 	if err != nil {
 		if stopErr := e.StopServices(getServiceNames(configs)...); stopErr != nil {
-			logger.Warnf("could not call stop successfuly: %v", stopErr)
+			e.compose.log.Warnf("could not call stop successfuly: %v", stopErr)
 		}
 		return err
 	}
@@ -96,11 +167,16 @@ func (e *Environment) StartServices(entries ...*ServiceEntry) error {
 }
 
 func (e *Environment) StopServices(services ...string) error {
+	return e.StopServicesContext(context.Background(), services...)
+}
+
+// StopServicesContext like StopServices, but stops waiting for the services once ctx is done
+func (e *Environment) StopServicesContext(ctx context.Context, services ...string) error {
 	configs := e.compose.getServiceConfigs(services...)
 	if len(configs) != len(services) {
 		return fmt.Errorf("can't stop unmanaged service contained in: %v", services)
 	}
-	err := e.compose.Stop(getServiceNames(configs)...)
+	err := e.compose.StopContext(ctx, getServiceNames(configs)...)
 	if err == nil {
 		for _, service := range services {
 			delete(e.Services, service)
@@ -109,17 +185,62 @@ func (e *Environment) StopServices(services ...string) error {
 	return err
 }
 
+// GetContainer returns the container of the service, or nil if it has none. See Compose.GetContainer
+func (e *Environment) GetContainer(service string) (*Container, error) {
+	return e.compose.GetContainer(service)
+}
+
+// GetContainers returns all the containers (replicas) of the service. See Compose.GetContainers
+func (e *Environment) GetContainers(service string) ([]*Container, error) {
+	return e.compose.GetContainers(service)
+}
+
 // Shutdown MUST be used by tests' cleanup functions or there may be container leaks
 func (e *Environment) Shutdown() {
+	e.ShutdownContext(context.Background())
+}
+
+// ShutdownContext like Shutdown, but stops waiting for the services to go down once ctx is done
+func (e *Environment) ShutdownContext(ctx context.Context) {
+	e.shutdownLock.Lock()
+	defer e.shutdownLock.Unlock()
+	if e.shutdown {
+		return
+	}
+	e.shutdown = true
+	defer registry.remove(e)
+	defer e.compose.Close()
+	if e.logs != nil {
+		defer e.logs.stop()
+	}
+	for _, summary := range e.compose.sampling.stop() {
+		e.compose.log.Infof("resource usage of container %s over %v: peak memory %d bytes, avg memory %d bytes, %.2f CPU seconds",
+			summary.Container, summary.Duration, summary.PeakMemory, summary.AvgMemory, summary.CPUSeconds)
+	}
+	e.captureArtifacts(ctx)
+	if err := e.compose.shaped.reset(ctx); err != nil {
+		e.compose.log.Warnf("could not reset traffic shaping: %v", err)
+	}
+	// also for environments left running (e.g. reused ones), whose containers must be able to reach each other
+	if len(e.partitions) > 0 {
+		if err := e.HealContext(ctx); err != nil {
+			e.compose.log.Warnf("could not heal network partitions: %v", err)
+		}
+	}
 	if e.noShutdown {
 		return
 	}
 	for _, hook := range e.shutdownHooks {
 		hook()
 	}
-	err := e.compose.Down()
+	err := e.compose.DownContext(ctx)
 	if err != nil {
-		logger.Error(err)
+		e.compose.log.Errorf("%v", err)
+	} else if e.reaper != nil {
+		// otherwise, leave the leftovers to the reaper
+		if err = e.reaper.unregister(e.compose.ProjectName()); err != nil {
+			e.compose.log.Warnf("could not unregister project %s from the reaper: %v", e.compose.ProjectName(), err)
+		}
 	}
 	for _, after := range e.afterHandlers {
 		after()
@@ -133,6 +254,12 @@ func (e *Environment) setupServiceConfigs(entries ...*ServiceEntry) error {
 		return nil
 	}
 	services := mapServiceEntries(entries...)
+	if e.entries == nil {
+		e.entries = make(map[string]*ServiceEntry)
+	}
+	for name, entry := range services {
+		e.entries[name] = entry
+	}
 	beforeHandlers, afterHandlers := getHandlers(services)
 	e.afterHandlers = append(e.afterHandlers, afterHandlers...)
 	for _, before := range beforeHandlers {
@@ -141,51 +268,91 @@ func (e *Environment) setupServiceConfigs(entries ...*ServiceEntry) error {
 		}
 	}
 	e.addShutdownHooks(services, func(config *ServiceEntry, container *Container) {
-		if !config.DisableShutdownLogs {
+		if e.tb != nil {
+			// only worth the noise if the test failed
+			if e.tb.Failed() {
+				logDiagnostics(e.tb, config, container)
+			}
+			return
+		}
+		if !config.DisableShutdownLogs && e.logs == nil {
 			PrintLogs(GREEN, container)
 		}
 	})
 	return nil
 }
 
+// startReaping registers the project with the reaper. The environment can still be used if that fails
+func (e *Environment) startReaping() {
+	r, err := getReaper()
+	if err == nil {
+		err = r.register(e.compose.ProjectName())
+	}
+	if err != nil {
+		e.compose.log.Warnf("project %s won't be reaped: %v", e.compose.ProjectName(), err)
+		return
+	}
+	e.reaper = r
+}
+
+// followLogs starts streaming the logs of the services' containers, if enabled
+func (e *Environment) followLogs(ctx context.Context, entries ...*ServiceEntry) {
+	if e.logs == nil {
+		return
+	}
+	for _, entry := range entries {
+		containers, err := e.compose.GetContainersContext(ctx, entry.Name)
+		if err != nil {
+			e.compose.log.Warnf("could not stream logs of service %s: %v", entry.Name, err)
+			continue
+		}
+		e.logs.follow(LogOptions{}, containers...)
+	}
+}
+
 func (e *Environment) addShutdownHooks(entries map[string]*ServiceEntry, hook func(config *ServiceEntry, container *Container)) {
 	for _, config := range entries {
 		config := config
 		e.shutdownHooks = append(e.shutdownHooks, func() {
-			container, err := e.compose.GetContainer(config.Name)
-			if container == nil {
-				return
-			}
+			containers, err := e.compose.GetContainers(config.Name)
 			if err != nil {
-				logger.Errorf("can't run container shutdown hook. err getting container for service %s", config.Name)
+				e.compose.log.Errorf("can't run container shutdown hook. err getting containers for service %s", config.Name)
 			}
-			if container == nil {
-				logger.Errorf("can't run container shutdown hook. no container found for service %s", config.Name)
+			for _, container := range containers {
+				hook(config, container)
 			}
-			hook(config, container)
 		})
 	}
 }
 
-func (e *Environment) invokeServiceHandlers(entries ...*ServiceEntry) error {
+func (e *Environment) invokeServiceHandlers(ctx context.Context, entries ...*ServiceEntry) error {
 	serviceOutputs := make(map[string]interface{})
 	for _, config := range entries {
-		container, err := e.compose.GetContainer(config.Name)
+		containers, err := e.compose.GetContainersContext(ctx, config.Name)
 		if err != nil {
 			return err
 		}
-		if container == nil {
+		if len(containers) == 0 {
 			return fmt.Errorf("no container found for service %s", config.Name)
 		}
 		var output interface{}
-		if config.Handler != nil {
-			logger.Infof("running handler for service %s", config.Name)
-			output, err = config.Handler(container)
+		if config.ClusterHandler != nil {
+			e.compose.log.Infof("running cluster handler for service %s", config.Name)
+			output, err = config.ClusterHandler(containers)
+			if err != nil {
+				return err
+			}
+		} else if config.Handler != nil {
+			if len(containers) > 1 {
+				return fmt.Errorf("service %s has %d containers. use a ClusterHandler for scaled services", config.Name, len(containers))
+			}
+			e.compose.log.Infof("running handler for service %s", config.Name)
+			output, err = config.Handler(containers[0])
 			if err != nil {
 				return err
 			}
 		} else {
-			logger.Infof("no handler found for service %s", config.Name)
+			e.compose.log.Infof("no handler found for service %s", config.Name)
 		}
 		serviceOutputs[config.Name] = output
 	}
@@ -208,6 +375,8 @@ func getServiceConfigsMap(entries map[string]*ServiceEntry) map[string]*ServiceC
 			Name:            entry.Name,
 			EnvironmentVars: entry.EnvironmentVars,
 			Network:         entry.Network,
+			WaitFor:         entry.WaitFor,
+			Replicas:        entry.Replicas,
 		}
 		if cfg.Network == "" {
 			cfg.Network = DefaultNetwork
@@ -224,6 +393,8 @@ func getServiceConfigs(entries ...*ServiceEntry) []*ServiceConfig {
 			Name:            entry.Name,
 			EnvironmentVars: entry.EnvironmentVars,
 			Network:         entry.Network,
+			WaitFor:         entry.WaitFor,
+			Replicas:        entry.Replicas,
 		}
 		if cfg.Network == "" {
 			cfg.Network = DefaultNetwork
@@ -0,0 +1,249 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/network"
+)
+
+type (
+	// partition a network split by Environment.Partition: one group stayed on the network, and the other was moved to
+	// an isolated copy of it, along with the network's other containers
+	partition struct {
+		network  string
+		isolated string
+		// moved the settings the moved containers had on the network, by container ID
+		moved map[string]*network.EndpointSettings
+		// bridged the containers of neither group, connected to both networks
+		bridged []string
+	}
+)
+
+// DisconnectNetwork disconnects the container from the network, given by its name or its name in the compose file
+func (c *Container) DisconnectNetwork(name string) error {
+	return c.DisconnectNetworkContext(context.Background(), name)
+}
+
+// DisconnectNetworkContext like DisconnectNetwork, with a context for the Docker API calls
+func (c *Container) DisconnectNetworkContext(ctx context.Context, name string) error {
+	networks, err := c.networks(ctx)
+	if err != nil {
+		return err
+	}
+	resolved := c.resolveNetwork(ctx, networks, name)
+	if networks[resolved] == nil {
+		return fmt.Errorf("container %s is not connected to network %s", c.Config.Names[0], name)
+	}
+	if err = c.cli.NetworkDisconnect(ctx, resolved, c.Config.ID, false); err != nil {
+		return fmt.Errorf("could not disconnect container %s from network %s: %w", c.Config.Names[0], resolved, err)
+	}
+	return nil
+}
+
+// ConnectNetwork connects the container to the network, given by its name or its name in the compose file, under the
+// given (optional) aliases
+func (c *Container) ConnectNetwork(name string, aliases ...string) error {
+	return c.ConnectNetworkContext(context.Background(), name, aliases...)
+}
+
+// ConnectNetworkContext like ConnectNetwork, with a context for the Docker API call
+func (c *Container) ConnectNetworkContext(ctx context.Context, name string, aliases ...string) error {
+	return c.connect(ctx, c.resolveNetwork(ctx, nil, name), &network.EndpointSettings{Aliases: aliases})
+}
+
+func (c *Container) connect(ctx context.Context, name string, settings *network.EndpointSettings) error {
+	if err := c.cli.NetworkConnect(ctx, name, c.Config.ID, settings); err != nil {
+		return fmt.Errorf("could not connect container %s to network %s: %w", c.Config.Names[0], name, err)
+	}
+	return nil
+}
+
+// networks the container's current endpoint settings, by network name
+func (c *Container) networks(ctx context.Context) (map[string]*network.EndpointSettings, error) {
+	inspection, err := c.cli.ContainerInspect(ctx, c.Config.ID)
+	if err != nil {
+		return nil, err
+	}
+	return inspection.NetworkSettings.Networks, nil
+}
+
+// resolveNetwork maps a compose file network name to the actual network name, which compose prefixes with the project
+// name unless set explicitly. If the container's networks aren't given, the project's prefix is assumed if the name
+// doesn't exist as is
+func (c *Container) resolveNetwork(ctx context.Context, networks map[string]*network.EndpointSettings, name string) string {
+	prefixed := c.Config.Labels[ProjectLabel] + "_" + name
+	if networks != nil {
+		if networks[name] == nil && networks[prefixed] != nil {
+			return prefixed
+		}
+		return name
+	}
+	if _, err := c.cli.NetworkInspect(ctx, name, network.InspectOptions{}); err != nil {
+		return prefixed
+	}
+	return name
+}
+
+// Partition isolates the services of groupA from those of groupB on every network they share: the containers of
+// groupB are moved to an isolated copy of the network. The network's other containers are connected to both, so they
+// can still reach either group. Fails if the groups share no network. Heal restores the networks
+func (e *Environment) Partition(groupA []string, groupB []string) error {
+	return e.PartitionContext(context.Background(), groupA, groupB)
+}
+
+// PartitionContext like Partition, with a context for the Docker API calls
+func (e *Environment) PartitionContext(ctx context.Context, groupA []string, groupB []string) error {
+	a, err := e.containerIDs(ctx, groupA)
+	if err != nil {
+		return err
+	}
+	b, err := e.containerIDs(ctx, groupB)
+	if err != nil {
+		return err
+	}
+	// the networks each group is on, with group B's settings on them
+	onA := make(map[string]bool)
+	onB := make(map[string]map[string]*network.EndpointSettings)
+	for _, cntr := range a {
+		networks, err := cntr.networks(ctx)
+		if err != nil {
+			return err
+		}
+		for name := range networks {
+			onA[name] = true
+		}
+	}
+	for _, cntr := range b {
+		networks, err := cntr.networks(ctx)
+		if err != nil {
+			return err
+		}
+		for name, settings := range networks {
+			if onB[name] == nil {
+				onB[name] = make(map[string]*network.EndpointSettings)
+			}
+			onB[name][cntr.Config.ID] = settings
+		}
+	}
+	var shared []string
+	for name := range onB {
+		if onA[name] {
+			shared = append(shared, name)
+		}
+	}
+	if len(shared) == 0 {
+		return fmt.Errorf("services %v and %v share no network", groupA, groupB)
+	}
+	for _, name := range shared {
+		p, err := e.split(ctx, name, a, b, onB[name])
+		if p != nil {
+			e.partitions = append(e.partitions, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// split moves the containers of group B off the network, onto an isolated copy
+func (e *Environment) split(ctx context.Context, name string, a, b map[string]*Container, moved map[string]*network.EndpointSettings) (*partition, error) {
+	cli := e.compose.cli
+	inspection, err := cli.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// scoped to the project, like its other networks, for parallel environments partitioning a same explicit network
+	project := e.compose.ProjectName()
+	isolated := fmt.Sprintf("%s_%s-partition-%d", project, strings.TrimPrefix(name, project+"_"), len(e.partitions)+1)
+	_, err = cli.NetworkCreate(ctx, isolated, network.CreateOptions{
+		Driver: inspection.Driver,
+		Labels: map[string]string{ProjectLabel: project},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create network %s: %w", isolated, err)
+	}
+	p := &partition{network: name, isolated: isolated, moved: make(map[string]*network.EndpointSettings)}
+	for id := range inspection.Containers {
+		if a[id] != nil || b[id] != nil {
+			continue
+		}
+		if err = cli.NetworkConnect(ctx, isolated, id, &network.EndpointSettings{}); err != nil {
+			return p, fmt.Errorf("could not connect container %s to network %s: %w", id, isolated, err)
+		}
+		p.bridged = append(p.bridged, id)
+	}
+	for id, settings := range moved {
+		cntr := b[id]
+		// keep the container reachable under the same names
+		if err = cntr.connect(ctx, isolated, &network.EndpointSettings{Aliases: settings.Aliases}); err != nil {
+			return p, err
+		}
+		if err = cli.NetworkDisconnect(ctx, name, id, false); err != nil {
+			return p, fmt.Errorf("could not disconnect container %s from network %s: %w", cntr.Config.Names[0], name, err)
+		}
+		p.moved[id] = settings
+	}
+	return p, nil
+}
+
+// Heal undoes all partitions: the moved containers are reconnected to their networks under their previous aliases and
+// static addresses, and the isolated networks are removed
+func (e *Environment) Heal() error {
+	return e.HealContext(context.Background())
+}
+
+// HealContext like Heal, with a context for the Docker API calls
+func (e *Environment) HealContext(ctx context.Context) error {
+	cli := e.compose.cli
+	var errs []error
+	// undo in reverse order, in case of partitions of the same network
+	for i := len(e.partitions) - 1; i >= 0; i-- {
+		p := e.partitions[i]
+		for id, settings := range p.moved {
+			restored := &network.EndpointSettings{
+				Aliases:    settings.Aliases,
+				Links:      settings.Links,
+				DriverOpts: settings.DriverOpts,
+				IPAMConfig: settings.IPAMConfig,
+			}
+			if err := cli.NetworkConnect(ctx, p.network, id, restored); err != nil {
+				errs = append(errs, fmt.Errorf("could not reconnect container %s to network %s: %w", id, p.network, err))
+			}
+			if err := cli.NetworkDisconnect(ctx, p.isolated, id, true); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		for _, id := range p.bridged {
+			if err := cli.NetworkDisconnect(ctx, p.isolated, id, true); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := cli.NetworkRemove(ctx, p.isolated); err != nil {
+			errs = append(errs, fmt.Errorf("could not remove network %s: %w", p.isolated, err))
+		}
+	}
+	e.partitions = nil
+	return errors.Join(errs...)
+}
+
+// containerIDs the containers of the services, by ID
+func (e *Environment) containerIDs(ctx context.Context, services []string) (map[string]*Container, error) {
+	containers := make(map[string]*Container)
+	for _, service := range services {
+		list, err := e.compose.GetContainersContext(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("no container found for service %s", service)
+		}
+		for _, cntr := range list {
+			containers[cntr.Config.ID] = cntr
+		}
+	}
+	return containers, nil
+}
@@ -0,0 +1,440 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Downstream the data flowing from the service to the client (default)
+	Downstream ToxicDirection = iota
+	// Upstream the data flowing from the client to the service
+	Upstream
+)
+
+type (
+	// ToxicDirection which way of a connection a toxic applies to
+	ToxicDirection int
+
+	// Toxic a fault a Proxy injects in its connections. See LatencyToxic, TimeoutToxic, ResetPeerToxic,
+	// BandwidthToxic, SlicerToxic and LimitDataToxic
+	Toxic interface {
+		direction() ToxicDirection
+	}
+	// LatencyToxic delays the data by Latency, give or take Jitter
+	LatencyToxic struct {
+		Direction ToxicDirection
+		Latency   time.Duration
+		Jitter    time.Duration
+	}
+	// TimeoutToxic stops all data from getting through, and closes the connections after Timeout. If Timeout is 0,
+	// the data is dropped until the toxic is removed
+	TimeoutToxic struct {
+		Timeout time.Duration
+	}
+	// ResetPeerToxic resets (TCP RST) the connections after Timeout
+	ResetPeerToxic struct {
+		Timeout time.Duration
+	}
+	// BandwidthToxic limits the throughput to BytesPerSecond
+	BandwidthToxic struct {
+		Direction      ToxicDirection
+		BytesPerSecond int64
+	}
+	// SlicerToxic splits the data into chunks of AverageSize, give or take SizeVariation, Delay apart
+	SlicerToxic struct {
+		Direction     ToxicDirection
+		AverageSize   int
+		SizeVariation int
+		Delay         time.Duration
+	}
+	// LimitDataToxic closes the connections once Bytes have been transmitted in its direction
+	LimitDataToxic struct {
+		Direction ToxicDirection
+		Bytes     int64
+	}
+
+	// Proxy an in-process TCP proxy in front of a service's endpoint, injecting faults (toxics) in its connections.
+	// Toxics can be added and removed at any time, and apply to the open connections too
+	Proxy struct {
+		listener net.Listener
+		target   string
+		lock     sync.Mutex
+		toxics   map[string]Toxic
+		conns    map[*proxyConn]struct{}
+		// changed is closed (and replaced) whenever the toxics change
+		changed chan struct{}
+		closed  bool
+	}
+	// ProxiedEndpoints Endpoints whose public ports are those of Proxy instances, each in front of the original port (see
+	// ProxyEndpoints)
+	ProxiedEndpoints struct {
+		proxies map[int]*Proxy
+	}
+
+	// proxyChunk data read from one side of a connection, to transmit to the other
+	proxyChunk struct {
+		data []byte
+		read time.Time
+	}
+	proxyConn struct {
+		proxy  *Proxy
+		client net.Conn
+		server net.Conn
+		// sent the bytes transmitted in each direction
+		sent      [2]int64
+		done      chan struct{}
+		closeOnce sync.Once
+	}
+)
+
+func (t *LatencyToxic) direction() ToxicDirection   { return t.Direction }
+func (t *TimeoutToxic) direction() ToxicDirection   { return Downstream }
+func (t *ResetPeerToxic) direction() ToxicDirection { return Downstream }
+func (t *BandwidthToxic) direction() ToxicDirection { return t.Direction }
+func (t *SlicerToxic) direction() ToxicDirection    { return t.Direction }
+func (t *LimitDataToxic) direction() ToxicDirection { return t.Direction }
+
+// NewProxy starts a proxy listening on a random local port, forwarding to the target address
+func NewProxy(target string) (*Proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		listener: listener,
+		target:   target,
+		toxics:   make(map[string]Toxic),
+		conns:    make(map[*proxyConn]struct{}),
+		changed:  make(chan struct{}),
+	}
+	go p.accept()
+	return p, nil
+}
+
+// Addr the host:port address the proxy listens on
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Port the port the proxy listens on
+func (p *Proxy) Port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+// AddToxic adds (or replaces) the named toxic
+func (p *Proxy) AddToxic(name string, toxic Toxic) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.toxics[name] = toxic
+	p.notify()
+}
+
+// RemoveToxic removes the named toxic
+func (p *Proxy) RemoveToxic(name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.toxics, name)
+	p.notify()
+}
+
+// ResetToxics removes all toxics
+func (p *Proxy) ResetToxics() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.toxics = make(map[string]Toxic)
+	p.notify()
+}
+
+// Close stops listening and closes all connections
+func (p *Proxy) Close() error {
+	p.lock.Lock()
+	p.closed = true
+	var conns []*proxyConn
+	for conn := range p.conns {
+		conns = append(conns, conn)
+	}
+	p.lock.Unlock()
+	err := p.listener.Close()
+	for _, conn := range conns {
+		conn.close(false)
+	}
+	return err
+}
+
+// notify wakes up the connections waiting for toxic changes. Must be called with the lock held
+func (p *Proxy) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// state the current toxics, and a channel closed once they change
+func (p *Proxy) state() (map[string]Toxic, <-chan struct{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return maps.Clone(p.toxics), p.changed
+}
+
+func (p *Proxy) accept() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			_ = client.Close()
+			continue
+		}
+		conn := &proxyConn{proxy: p, client: client, server: server, done: make(chan struct{})}
+		p.lock.Lock()
+		if p.closed {
+			p.lock.Unlock()
+			conn.close(false)
+			return
+		}
+		p.conns[conn] = struct{}{}
+		p.lock.Unlock()
+		go conn.pipe(Upstream, client, server)
+		go conn.pipe(Downstream, server, client)
+		go conn.watch()
+	}
+}
+
+// pipe forwards the data from src to dst through the toxics, until either side is closed. The data is read as it
+// comes, and queued for the toxics, so that latency is added to each chunk from when it was read, rather than cumulated
+func (c *proxyConn) pipe(direction ToxicDirection, src net.Conn, dst net.Conn) {
+	defer c.close(false)
+	chunks := make(chan proxyChunk, 64)
+	go func() {
+		defer close(chunks)
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := src.Read(buf)
+			if n > 0 {
+				select {
+				case chunks <- proxyChunk{data: buf[:n], read: time.Now()}:
+				case <-c.done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for chunk := range chunks {
+		if !c.transmit(direction, dst, chunk) {
+			return
+		}
+	}
+}
+
+// transmit writes the data to dst, applying the toxics of the direction. Returns false if the connection must be
+// closed
+func (c *proxyConn) transmit(direction ToxicDirection, dst net.Conn, chunk proxyChunk) bool {
+	data := chunk.data
+	toxics, _ := c.proxy.state()
+	chunkSize, chunkDelay := len(data), time.Duration(0)
+	for _, toxic := range toxics {
+		if _, ok := toxic.(*TimeoutToxic); ok {
+			// dropped
+			return true
+		}
+		if toxic.direction() != direction {
+			continue
+		}
+		switch t := toxic.(type) {
+		case *LatencyToxic:
+			if !c.sleep(time.Until(chunk.read.Add(jittered(t.Latency, t.Jitter)))) {
+				return false
+			}
+		case *BandwidthToxic:
+			if t.BytesPerSecond > 0 && !c.sleep(time.Duration(int64(len(data))*int64(time.Second)/t.BytesPerSecond)) {
+				return false
+			}
+		case *SlicerToxic:
+			chunkSize, chunkDelay = t.AverageSize, t.Delay
+			if t.SizeVariation > 0 {
+				chunkSize += rand.Intn(2*t.SizeVariation+1) - t.SizeVariation
+			}
+			if chunkSize < 1 {
+				chunkSize = 1
+			}
+		case *LimitDataToxic:
+			if remaining := t.Bytes - c.sent[direction]; remaining < int64(len(data)) {
+				if remaining > 0 {
+					_, _ = dst.Write(data[:remaining])
+				}
+				return false
+			}
+		}
+	}
+	for len(data) > 0 {
+		size := chunkSize
+		if size > len(data) {
+			size = len(data)
+		}
+		if _, err := dst.Write(data[:size]); err != nil {
+			return false
+		}
+		c.sent[direction] += int64(size)
+		data = data[size:]
+		if len(data) > 0 && chunkDelay > 0 && !c.sleep(chunkDelay) {
+			return false
+		}
+	}
+	return true
+}
+
+// sleep waits for d, unless the connection is closed first. Returns false if it was
+func (c *proxyConn) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// watch applies the toxics acting on the connection as a whole. Their timers start when they are added, and are
+// stopped when they are removed, regardless of the other toxics
+func (c *proxyConn) watch() {
+	timers := make(map[Toxic]*time.Timer)
+	defer func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}()
+	for {
+		toxics, changed := c.proxy.state()
+		active := make(map[Toxic]bool)
+		for _, toxic := range toxics {
+			active[toxic] = true
+			if timers[toxic] != nil {
+				continue
+			}
+			switch t := toxic.(type) {
+			case *TimeoutToxic:
+				if t.Timeout > 0 {
+					timers[toxic] = time.AfterFunc(t.Timeout, func() { c.close(false) })
+				}
+			case *ResetPeerToxic:
+				timers[toxic] = time.AfterFunc(t.Timeout, func() { c.close(true) })
+			}
+		}
+		for toxic, timer := range timers {
+			if !active[toxic] {
+				timer.Stop()
+				delete(timers, toxic)
+			}
+		}
+		select {
+		case <-changed:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// close closes both sides of the connection. If reset is set, the client gets a TCP RST instead of a FIN
+func (c *proxyConn) close(reset bool) {
+	c.closeOnce.Do(func() {
+		if tcp, ok := c.client.(*net.TCPConn); ok && reset {
+			_ = tcp.SetLinger(0)
+		}
+		_ = c.client.Close()
+		_ = c.server.Close()
+		close(c.done)
+		c.proxy.lock.Lock()
+		delete(c.proxy.conns, c)
+		c.proxy.lock.Unlock()
+	})
+}
+
+func jittered(latency time.Duration, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return latency
+	}
+	return latency + time.Duration(rand.Int63n(int64(2*jitter)+1)) - jitter
+}
+
+// ProxyEndpoints starts a Proxy in front of each of the private ports' public port, for fault injection (see
+// Endpoints.Proxied). The returned Endpoints list the proxies' ports instead. Close them once done
+func ProxyEndpoints(endpoints Endpoints, privatePorts ...int) (*ProxiedEndpoints, error) {
+	if len(privatePorts) == 0 {
+		return nil, errors.New("no port to proxy")
+	}
+	proxied := &ProxiedEndpoints{proxies: make(map[int]*Proxy)}
+	for _, private := range privatePorts {
+		public := endpoints.GetPublicPorts(private)
+		if len(public) == 0 {
+			_ = proxied.Close()
+			return nil, fmt.Errorf("port %d is not published", private)
+		}
+		proxy, err := NewProxy(net.JoinHostPort(endpoints.GetHost(), strconv.Itoa(public[0])))
+		if err != nil {
+			_ = proxied.Close()
+			return nil, fmt.Errorf("could not proxy port %d: %w", private, err)
+		}
+		proxied.proxies[private] = proxy
+	}
+	return proxied, nil
+}
+
+func (p *endpoints) Proxied(privatePorts ...int) (*ProxiedEndpoints, error) {
+	if len(privatePorts) == 0 {
+		privatePorts = slices.Sorted(maps.Keys(p.ports))
+	}
+	return ProxyEndpoints(p, privatePorts...)
+}
+
+func (e *ProxiedEndpoints) GetHost() string {
+	return "127.0.0.1"
+}
+
+func (e *ProxiedEndpoints) GetPublicPorts(privatePorts ...int) []int {
+	var ports []int
+	for private, proxy := range e.proxies {
+		if len(privatePorts) == 0 || slices.Contains(privatePorts, private) {
+			ports = append(ports, proxy.Port())
+		}
+	}
+	return ports
+}
+
+// Proxied starts a Proxy in front of each of the proxies (all of them if none given), e.g. to add toxics on both sides
+func (e *ProxiedEndpoints) Proxied(privatePorts ...int) (*ProxiedEndpoints, error) {
+	if len(privatePorts) == 0 {
+		privatePorts = slices.Sorted(maps.Keys(e.proxies))
+	}
+	return ProxyEndpoints(e, privatePorts...)
+}
+
+// Proxy the proxy in front of the private port, or nil if it isn't published
+func (e *ProxiedEndpoints) Proxy(privatePort int) *Proxy {
+	return e.proxies[privatePort]
+}
+
+// Close closes all the proxies
+func (e *ProxiedEndpoints) Close() error {
+	var errs []error
+	for _, proxy := range e.proxies {
+		if err := proxy.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
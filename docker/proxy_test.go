@@ -0,0 +1,264 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// recordingConn a net.Conn recording the writes
+type recordingConn struct {
+	net.Conn
+	writes [][]byte
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestJittered(t *testing.T) {
+	tests := []struct {
+		latency time.Duration
+		jitter  time.Duration
+	}{
+		{latency: 100 * time.Millisecond},
+		{latency: 100 * time.Millisecond, jitter: 10 * time.Millisecond},
+		{latency: 0, jitter: time.Millisecond},
+		{latency: time.Second, jitter: -time.Millisecond},
+	}
+	for _, tt := range tests {
+		jitter := max(tt.jitter, 0)
+		for i := 0; i < 1000; i++ {
+			if got := jittered(tt.latency, tt.jitter); got < tt.latency-jitter || got > tt.latency+jitter {
+				t.Fatalf("jittered(%v, %v) = %v, out of bounds", tt.latency, tt.jitter, got)
+			}
+		}
+	}
+}
+
+func TestProxyConn_Transmit(t *testing.T) {
+	data := []byte("0123456789")
+	tests := []struct {
+		name   string
+		toxics map[string]Toxic
+		// sent the bytes already transmitted downstream
+		sent   int64
+		writes []string
+		// open whether the connection stays open
+		open bool
+	}{
+		{name: "no toxic", writes: []string{"0123456789"}, open: true},
+		{name: "timeout drops the data", toxics: map[string]Toxic{"t": &TimeoutToxic{}}, open: true},
+		{
+			name:   "slicer",
+			toxics: map[string]Toxic{"s": &SlicerToxic{AverageSize: 4}},
+			writes: []string{"0123", "4567", "89"},
+			open:   true,
+		},
+		{
+			name:   "slicer of the other direction",
+			toxics: map[string]Toxic{"s": &SlicerToxic{Direction: Upstream, AverageSize: 4}},
+			writes: []string{"0123456789"},
+			open:   true,
+		},
+		{
+			name:   "slices of at least a byte",
+			toxics: map[string]Toxic{"s": &SlicerToxic{AverageSize: 0}},
+			writes: []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"},
+			open:   true,
+		},
+		{name: "under the data limit", toxics: map[string]Toxic{"l": &LimitDataToxic{Bytes: 20}}, writes: []string{"0123456789"}, open: true},
+		{name: "at the data limit", toxics: map[string]Toxic{"l": &LimitDataToxic{Bytes: 10}}, writes: []string{"0123456789"}, open: true},
+		{name: "over the data limit", toxics: map[string]Toxic{"l": &LimitDataToxic{Bytes: 4}}, writes: []string{"0123"}},
+		{name: "data limit already reached", toxics: map[string]Toxic{"l": &LimitDataToxic{Bytes: 4}}, sent: 4},
+		{name: "data limit partly reached", toxics: map[string]Toxic{"l": &LimitDataToxic{Bytes: 6}}, sent: 4, writes: []string{"01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toxics := tt.toxics
+			if toxics == nil {
+				toxics = make(map[string]Toxic)
+			}
+			conn := &proxyConn{proxy: &Proxy{toxics: toxics}, done: make(chan struct{})}
+			conn.sent[Downstream] = tt.sent
+			dst := new(recordingConn)
+			open := conn.transmit(Downstream, dst, proxyChunk{data: data, read: time.Now()})
+			if open != tt.open {
+				t.Errorf("open %v, want %v", open, tt.open)
+			}
+			var writes []string
+			for _, w := range dst.writes {
+				writes = append(writes, string(w))
+			}
+			if !reflect.DeepEqual(writes, tt.writes) {
+				t.Errorf("writes %q, want %q", writes, tt.writes)
+			}
+		})
+	}
+}
+
+func TestProxyConn_TransmitDelays(t *testing.T) {
+	tests := []struct {
+		name   string
+		toxic  Toxic
+		size   int
+		readAt time.Duration
+		delay  time.Duration
+	}{
+		{name: "latency", toxic: &LatencyToxic{Latency: 100 * time.Millisecond}, size: 10, delay: 100 * time.Millisecond},
+		{name: "latency from the read", toxic: &LatencyToxic{Latency: 100 * time.Millisecond}, size: 10, readAt: -60 * time.Millisecond, delay: 40 * time.Millisecond},
+		{name: "latency elapsed", toxic: &LatencyToxic{Latency: 100 * time.Millisecond}, size: 10, readAt: -time.Second},
+		{name: "bandwidth", toxic: &BandwidthToxic{BytesPerSecond: 1000}, size: 100, delay: 100 * time.Millisecond},
+		{name: "slices delay", toxic: &SlicerToxic{AverageSize: 2, Delay: 20 * time.Millisecond}, size: 8, delay: 60 * time.Millisecond},
+		{name: "other direction", toxic: &LatencyToxic{Direction: Upstream, Latency: time.Second}, size: 10},
+	}
+	// the scheduling slack allowed
+	const slack = 100 * time.Millisecond
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &proxyConn{proxy: &Proxy{toxics: map[string]Toxic{"toxic": tt.toxic}}, done: make(chan struct{})}
+			dst := new(recordingConn)
+			start := time.Now()
+			if !conn.transmit(Downstream, dst, proxyChunk{data: make([]byte, tt.size), read: start.Add(tt.readAt)}) {
+				t.Fatal("connection closed")
+			}
+			if elapsed := time.Since(start); elapsed < tt.delay || elapsed > tt.delay+slack {
+				t.Errorf("took %v, want %v", elapsed, tt.delay)
+			}
+		})
+	}
+}
+
+func TestProxyConn_TransmitClosed(t *testing.T) {
+	conn := &proxyConn{
+		proxy: &Proxy{toxics: map[string]Toxic{"latency": &LatencyToxic{Latency: time.Hour}}},
+		done:  make(chan struct{}),
+	}
+	close(conn.done)
+	if conn.transmit(Downstream, new(recordingConn), proxyChunk{data: []byte("data"), read: time.Now()}) {
+		t.Error("a closed connection must stop waiting")
+	}
+}
+
+// startEchoServer listens on a random local port, sending back whatever it receives
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestProxy(t *testing.T) {
+	echo := startEchoServer(t)
+	proxy, err := NewProxy(echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTrip := func(msg string) (string, error) {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return "", err
+		}
+		buf := make([]byte, len(msg))
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := io.ReadFull(conn, buf)
+		return string(buf[:n]), err
+	}
+	if got, err := roundTrip("ping"); err != nil || got != "ping" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	// applies to the open connection
+	proxy.AddToxic("latency", &LatencyToxic{Latency: 200 * time.Millisecond})
+	start := time.Now()
+	if got, err := roundTrip("slow"); err != nil || got != "slow" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("round trip took %v despite the latency", elapsed)
+	}
+	proxy.RemoveToxic("latency")
+	// counting the 8 bytes already received
+	proxy.AddToxic("limit", &LimitDataToxic{Bytes: 10})
+	if got, err := roundTrip("data"); got != "da" || err == nil {
+		t.Errorf("got %q, %v: the connection must be closed after the limit", got, err)
+	}
+}
+
+func TestProxy_ResetPeer(t *testing.T) {
+	echo := startEchoServer(t)
+	proxy, err := NewProxy(echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	proxy.AddToxic("reset", &ResetPeerToxic{Timeout: 100 * time.Millisecond})
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	if err == nil || errors.Is(err, io.EOF) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		t.Errorf("got %v, want a connection reset", err)
+	}
+}
+
+func TestEndpoints_Proxied(t *testing.T) {
+	echo := startEchoServer(t)
+	port := echo.Addr().(*net.TCPAddr).Port
+	endpoints := &endpoints{host: "127.0.0.1", ports: map[int][]int{80: {port}, 443: {}}}
+	if _, err := endpoints.Proxied(443); err == nil {
+		t.Error("an unpublished port can't be proxied")
+	}
+	proxied, err := endpoints.Proxied(80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxied.Close()
+	ports := proxied.GetPublicPorts(80)
+	if len(ports) != 1 || ports[0] != proxied.Proxy(80).Port() {
+		t.Fatalf("public ports %v, want the proxy's", ports)
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(proxied.GetHost(), strconv.Itoa(ports[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, []byte("ping")) {
+		t.Errorf("got %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,147 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+const (
+	// envReaper set in the environment of the reaper process, which runs the current executable (see RunReaperIfChild)
+	envReaper = "GO_COMPOSE_KEON94_REAPER_CHILD"
+	// reapTimeout how long the reaper tries to remove a project
+	reapTimeout = 2 * time.Minute
+)
+
+type (
+	// reaper a watchdog process that removes the registered projects once the process that started it dies. The
+	// projects are sent over its stdin, which is closed by the OS when this process exits, however it exits
+	reaper struct {
+		lock  sync.Mutex
+		stdin io.WriteCloser
+	}
+)
+
+var (
+	sessionReaper     *reaper
+	sessionReaperErr  error
+	sessionReaperOnce sync.Once
+	// reaperHooked whether RunReaperIfChild was called, without which the reaper process can't be started
+	reaperHooked atomic.Bool
+)
+
+// RunReaperIfChild runs the reaper and exits if the process is the reaper of another one (see EnvironmentConfig.Reap),
+// and returns otherwise. The reaper process runs the current executable, so this must be called first thing in TestMain
+// (or main) for Reap to work:
+//
+//	func TestMain(m *testing.M) {
+//		docker.RunReaperIfChild()
+//		os.Exit(m.Run())
+//	}
+func RunReaperIfChild() {
+	if os.Getenv(envReaper) != "" {
+		runReaper(os.Stdin)
+		os.Exit(0)
+	}
+	reaperHooked.Store(true)
+}
+
+// getReaper starts the reaper process on first use
+func getReaper() (*reaper, error) {
+	sessionReaperOnce.Do(func() {
+		sessionReaper, sessionReaperErr = startReaper()
+	})
+	return sessionReaper, sessionReaperErr
+}
+
+func startReaper() (*reaper, error) {
+	if !reaperHooked.Load() {
+		return nil, errors.New("docker.RunReaperIfChild must be called from TestMain first")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("could not start reaper: %w", err)
+	}
+	// no output: go test waits for the pipes of its test binary to be closed, and the reaper outlives it
+	cmd := exec.Command(executable)
+	cmd.Env = append(os.Environ(), envReaper+"=1")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not start reaper: %w", err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start reaper: %w", err)
+	}
+	go func() {
+		_ = cmd.Wait()
+	}()
+	return &reaper{stdin: stdin}, nil
+}
+
+// register makes the reaper remove the project if this process dies
+func (r *reaper) register(project string) error {
+	return r.send("+" + project)
+}
+
+// unregister makes the reaper forget about the project, once it's been brought down
+func (r *reaper) unregister(project string) error {
+	return r.send("-" + project)
+}
+
+func (r *reaper) send(msg string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, err := fmt.Fprintln(r.stdin, msg)
+	return err
+}
+
+// runReaper the reaper process' main loop. It tracks the projects read from the input until it's closed, then removes
+// their containers, networks and volumes
+func runReaper(input io.Reader) {
+	// the signals meant for the test process (e.g. Ctrl-C) reach the whole process group
+	signal.Ignore(os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	projects := make(map[string]bool)
+	var order []string
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if project, ok := strings.CutPrefix(line, "+"); ok {
+			if !projects[project] {
+				order = append(order, project)
+			}
+			projects[project] = true
+		} else if project, ok = strings.CutPrefix(line, "-"); ok {
+			projects[project] = false
+		}
+	}
+	if len(order) == 0 {
+		return
+	}
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return
+	}
+	defer cli.Close()
+	for _, project := range order {
+		if !projects[project] {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), reapTimeout)
+		// the engine backend only needs the client and project name to bring a project down
+		backend := &engineBackend{compose: &Compose{cli: cli, project: project}}
+		_ = backend.down(ctx)
+		cancel()
+	}
+}
@@ -0,0 +1,155 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// serviceHashInput what makes up a service's effective configuration
+	serviceHashInput struct {
+		Service  *composeService
+		Env      []string
+		ImageID  string
+		Replicas int
+	}
+	// labelsOverride a compose file that only adds labels to services
+	labelsOverride struct {
+		Version  string                   `yaml:"version,omitempty"`
+		Services map[string]labelsOverlay `yaml:"services"`
+	}
+	labelsOverlay struct {
+		Labels map[string]string `yaml:"labels"`
+	}
+)
+
+// upServices brings up the services. With EnvironmentConfig.Reuse, the services whose running containers match their
+// current configuration are left untouched, and only the others are recreated
+func (c *Compose) upServices(ctx context.Context, services []string, renewVolumes bool) error {
+	if !c.config.Env.Reuse {
+		return c.backend.up(ctx, services, renewVolumes)
+	}
+	stale, err := c.staleServices(ctx, services)
+	if err != nil {
+		return err
+	}
+	if reused := len(services) - len(stale); reused > 0 {
+		c.log.Infof("reusing the running containers of %d service(s)", reused)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	c.log.Infof("recreating services %v", stale)
+	if err = c.backend.stop(ctx, stale); err != nil {
+		return err
+	}
+	return c.backend.up(ctx, stale, false)
+}
+
+// staleServices hashes the services' effective configurations and returns those whose containers don't all carry the
+// hash, or aren't all running. Changes to networks and volumes, and to build contexts, aren't accounted for
+func (c *Compose) staleServices(ctx context.Context, services []string) ([]string, error) {
+	file, err := loadComposeFiles(c.config.Env.ComposeFilePaths, c.getEnvVariables())
+	if err != nil {
+		return nil, err
+	}
+	if c.hashes == nil {
+		c.hashes = make(map[string]string)
+	}
+	var stale []string
+	for _, name := range services {
+		service := file.Services[name]
+		if service == nil {
+			return nil, fmt.Errorf("service %s not found in the compose files", name)
+		}
+		replicas := service.replicas()
+		if cfg := c.config.Services[name]; cfg != nil && cfg.Replicas > 0 {
+			replicas = cfg.Replicas
+		}
+		hash, err := c.hashService(ctx, name, service, replicas)
+		if err != nil {
+			return nil, err
+		}
+		c.hashes[name] = hash
+		cntrs, err := c.GetContainersContext(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		upToDate := len(cntrs) == replicas
+		for _, cntr := range cntrs {
+			if cntr.Config.State != "running" || cntr.Config.Labels[ConfigHashLabel] != hash {
+				upToDate = false
+			}
+		}
+		if !upToDate {
+			stale = append(stale, name)
+		}
+	}
+	if c.config.Env.Backend == BackendEngine {
+		// the engine backend sets the label itself
+		return stale, nil
+	}
+	return stale, c.writeLabelsOverride(file.Version)
+}
+
+func (c *Compose) hashService(ctx context.Context, name string, service *composeService, replicas int) (string, error) {
+	input := serviceHashInput{Service: service, Replicas: replicas}
+	if cfg := c.config.Services[name]; cfg != nil {
+		for k, v := range cfg.EnvironmentVars {
+			input.Env = append(input.Env, k+"="+v)
+		}
+		sort.Strings(input.Env)
+	}
+	if service.Image != "" {
+		// pull it first, or the hash would change once it's been pulled by the up
+		if err := (&engineBackend{compose: c}).ensureImage(ctx, service.Image); err != nil {
+			return "", err
+		}
+		inspection, err := c.cli.ImageInspect(ctx, service.Image)
+		if err != nil {
+			return "", fmt.Errorf("could not inspect image %s: %w", service.Image, err)
+		}
+		input.ImageID = inspection.ID
+	}
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// configHash the hash of the service's effective configuration, if it was computed
+func (c *Compose) configHash(service string) (string, bool) {
+	hash, ok := c.hashes[service]
+	return hash, ok
+}
+
+// writeLabelsOverride (re)writes the compose file labelling the services' containers with their hashes, which is
+// passed to the compose CLI after the user's files
+func (c *Compose) writeLabelsOverride(version string) error {
+	override := labelsOverride{Version: version, Services: make(map[string]labelsOverlay)}
+	for name, hash := range c.hashes {
+		override.Services[name] = labelsOverlay{Labels: map[string]string{ConfigHashLabel: hash}}
+	}
+	content, err := yaml.Marshal(override)
+	if err != nil {
+		return err
+	}
+	if c.overrideFile == "" {
+		file, err := os.CreateTemp("", "go-compose-*.yml")
+		if err != nil {
+			return fmt.Errorf("could not create labels override file: %w", err)
+		}
+		c.overrideFile = file.Name()
+		_ = file.Close()
+	}
+	return os.WriteFile(c.overrideFile, content, 0644)
+}
@@ -0,0 +1,195 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// interfaceName the network interface names accepted, which are put in the tc script verbatim
+var interfaceName = regexp.MustCompile(`^[A-Za-z0-9@._-]+$`)
+
+// rateValue the bandwidth limits accepted, which are put in the tc script verbatim
+var rateValue = regexp.MustCompile(`^[0-9.]+[a-zA-Z]*$`)
+
+// TrafficHelperImage the image of the helper container shaping the traffic of containers whose image lacks tc. It must
+// have sh and tc
+var TrafficHelperImage = "gaiadocker/iproute2:latest"
+
+type (
+	// TrafficRules how the traffic leaving a container is degraded (see tc-netem). Only the fields set are applied
+	TrafficRules struct {
+		// Interface optional network interface to shape. Defaults to all of them but the loopback
+		Interface string
+		// Delay added to each packet
+		Delay time.Duration
+		// Jitter random variation of the Delay
+		Jitter time.Duration
+		// Loss percentage (0-100) of packets dropped
+		Loss float64
+		// Rate bandwidth limit, in tc's units (e.g. "1mbit", "512kbit")
+		Rate string
+	}
+
+	// shapedContainers the containers whose traffic is shaped, to reset on shutdown
+	shapedContainers struct {
+		lock       sync.Mutex
+		containers map[string]*Container
+	}
+)
+
+// netem the tc-netem arguments of the rules
+func (r TrafficRules) netem() ([]string, error) {
+	if r.Interface != "" && !interfaceName.MatchString(r.Interface) {
+		return nil, fmt.Errorf("invalid network interface name %q", r.Interface)
+	}
+	var args []string
+	if r.Delay > 0 {
+		args = append(args, "delay", fmt.Sprintf("%dus", r.Delay.Microseconds()))
+		if r.Jitter > 0 {
+			args = append(args, fmt.Sprintf("%dus", r.Jitter.Microseconds()))
+		}
+	} else if r.Jitter > 0 {
+		return nil, errors.New("jitter requires a delay")
+	}
+	if r.Loss < 0 || r.Loss > 100 {
+		return nil, fmt.Errorf("invalid loss percentage %v", r.Loss)
+	} else if r.Loss > 0 {
+		args = append(args, "loss", fmt.Sprintf("%g%%", r.Loss))
+	}
+	if r.Rate != "" {
+		if !rateValue.MatchString(r.Rate) {
+			return nil, fmt.Errorf("invalid rate %q", r.Rate)
+		}
+		args = append(args, "rate", r.Rate)
+	}
+	if len(args) == 0 {
+		return nil, errors.New("no traffic rule set")
+	}
+	return args, nil
+}
+
+// ShapeTraffic applies the rules to the traffic leaving the container, replacing any previous ones. If the container's
+// image has no tc, a short-lived helper container (see TrafficHelperImage) sharing its network namespace applies them.
+// The rules are removed by ResetTraffic, or on Environment.Shutdown
+func (c *Container) ShapeTraffic(ctx context.Context, rules TrafficRules) error {
+	netem, err := rules.netem()
+	if err != nil {
+		return err
+	}
+	cmd := "tc qdisc replace dev \"$dev\" root netem " + strings.Join(netem, " ")
+	if err = c.runTC(ctx, shellForInterfaces(rules.Interface, cmd)); err != nil {
+		return fmt.Errorf("could not shape traffic of container %s: %w", c.Config.Names[0], err)
+	}
+	if c.shaped != nil {
+		c.shaped.add(c)
+	}
+	return nil
+}
+
+// ResetTraffic removes the rules applied by ShapeTraffic
+func (c *Container) ResetTraffic(ctx context.Context) error {
+	script := shellForInterfaces("", "tc qdisc del dev \"$dev\" root 2>/dev/null || true")
+	if err := c.runTC(ctx, script); err != nil {
+		return fmt.Errorf("could not reset traffic of container %s: %w", c.Config.Names[0], err)
+	}
+	if c.shaped != nil {
+		c.shaped.remove(c)
+	}
+	return nil
+}
+
+// shellForInterfaces a script running cmd for the interface (or all but the loopback), given as $dev
+func shellForInterfaces(iface string, cmd string) string {
+	devices := "$(ls /sys/class/net)"
+	if iface != "" {
+		devices = "'" + iface + "'"
+	}
+	return fmt.Sprintf(`set -e; for dev in %s; do [ "$dev" = lo ] && continue; %s; done`, devices, cmd)
+}
+
+// runTC runs the tc script in the container if it has tc, otherwise in a helper container sharing its network
+func (c *Container) runTC(ctx context.Context, script string) error {
+	probe, err := c.ExecWithOptions(ctx, ExecOptions{Cmd: []string{"tc", "-V"}})
+	if err == nil && probe.ExitCode == 0 {
+		_, err = c.ExecOrFail(ctx, ExecOptions{Shell: script, User: "0", Privileged: true})
+		return err
+	}
+	return c.runTCHelper(ctx, script)
+}
+
+func (c *Container) runTCHelper(ctx context.Context, script string) error {
+	if err := (&engineBackend{compose: &Compose{cli: c.cli, log: c.logger()}}).ensureImage(ctx, TrafficHelperImage); err != nil {
+		return err
+	}
+	created, err := c.cli.ContainerCreate(ctx, &container.Config{
+		Image:      TrafficHelperImage,
+		Entrypoint: []string{"sh", "-c", script},
+		// removed along with the project if the process dies before removing it
+		Labels: map[string]string{ProjectLabel: c.Config.Labels[ProjectLabel], ServiceLabel: "traffic-helper"},
+	}, &container.HostConfig{
+		NetworkMode: container.NetworkMode("container:" + c.Config.ID),
+		CapAdd:      []string{"NET_ADMIN"},
+	}, nil, nil, "")
+	if err != nil {
+		return &EngineError{Op: "create", Resource: "container", Name: "traffic helper", Err: err}
+	}
+	defer func() {
+		_ = c.cli.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+	}()
+	waitCh, errCh := c.cli.ContainerWait(ctx, created.ID, container.WaitConditionNextExit)
+	if err = c.cli.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return &EngineError{Op: "start", Resource: "container", Name: "traffic helper", Err: err}
+	}
+	select {
+	case result := <-waitCh:
+		if result.StatusCode == 0 {
+			return nil
+		}
+		helper := &Container{cli: c.cli, Config: &container.Summary{ID: created.ID, Names: []string{"/traffic-helper"}}}
+		var output []string
+		if lines, err := helper.streamLogs(ctx, LogOptions{}, false); err == nil {
+			for line := range lines {
+				output = append(output, line.Text)
+			}
+		}
+		return fmt.Errorf("traffic helper exited with code %d: %s", result.StatusCode, strings.Join(output, "\n"))
+	case err = <-errCh:
+		return err
+	}
+}
+
+func (s *shapedContainers) add(c *Container) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.containers[c.Config.ID] = c
+}
+
+func (s *shapedContainers) remove(c *Container) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.containers, c.Config.ID)
+}
+
+// reset resets the traffic of all shaped containers
+func (s *shapedContainers) reset(ctx context.Context) error {
+	s.lock.Lock()
+	var containers []*Container
+	for _, c := range s.containers {
+		containers = append(containers, c)
+	}
+	s.lock.Unlock()
+	var errs []error
+	for _, c := range containers {
+		if err := c.ResetTraffic(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
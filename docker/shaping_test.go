@@ -0,0 +1,56 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrafficRules_Netem(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   TrafficRules
+		want    []string
+		wantErr bool
+	}{
+		{name: "delay", rules: TrafficRules{Delay: 100 * time.Millisecond}, want: []string{"delay", "100000us"}},
+		{name: "jitter", rules: TrafficRules{Delay: time.Second, Jitter: 1500 * time.Microsecond}, want: []string{"delay", "1000000us", "1500us"}},
+		{name: "loss", rules: TrafficRules{Loss: 12.5}, want: []string{"loss", "12.5%"}},
+		{name: "rate", rules: TrafficRules{Rate: "1mbit"}, want: []string{"rate", "1mbit"}},
+		{name: "fractional rate", rules: TrafficRules{Rate: "1.5mbit"}, want: []string{"rate", "1.5mbit"}},
+		{name: "unit-less rate", rules: TrafficRules{Rate: "1000"}, want: []string{"rate", "1000"}},
+		{
+			name:  "all",
+			rules: TrafficRules{Interface: "eth0", Delay: time.Millisecond, Loss: 100, Rate: "512kbit"},
+			want:  []string{"delay", "1000us", "loss", "100%", "rate", "512kbit"},
+		},
+		{name: "interface with a peer", rules: TrafficRules{Interface: "eth0@if12", Loss: 1}, want: []string{"loss", "1%"}},
+		{name: "nothing", rules: TrafficRules{}, wantErr: true},
+		{name: "interface only", rules: TrafficRules{Interface: "eth0"}, wantErr: true},
+		{name: "jitter without delay", rules: TrafficRules{Jitter: time.Millisecond}, wantErr: true},
+		{name: "negative loss", rules: TrafficRules{Loss: -1}, wantErr: true},
+		{name: "loss over 100", rules: TrafficRules{Loss: 101}, wantErr: true},
+		{name: "interface injection", rules: TrafficRules{Interface: "eth0; reboot", Loss: 1}, wantErr: true},
+		{name: "interface with spaces", rules: TrafficRules{Interface: "eth 0", Loss: 1}, wantErr: true},
+		{name: "rate injection", rules: TrafficRules{Rate: "1mbit; rm -rf /"}, wantErr: true},
+		{name: "rate substitution", rules: TrafficRules{Rate: "$(reboot)"}, wantErr: true},
+		{name: "rate without a value", rules: TrafficRules{Rate: "mbit"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rules.netem()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
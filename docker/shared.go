@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type (
+	// SharedEnvironment an environment declared once (e.g. in TestMain) and shared by the tests that acquire it. It is
+	// started by the first Acquire, and shut down when the last reference is released. Hold a reference for the whole
+	// run (e.g. around m.Run) so that it isn't restarted between tests
+	SharedEnvironment struct {
+		config  *EnvironmentConfig
+		entries []*ServiceEntry
+		// reset optional hook run whenever the environment is acquired while already up
+		reset ResetHandler
+		lock  sync.Mutex
+		env   *Environment
+		refs  int
+	}
+	// ResetHandler clears the state left in the environment by previous tests
+	ResetHandler func(env *Environment) error
+)
+
+// NewSharedEnvironment declares a shared environment. Nothing is started until it is acquired
+func NewSharedEnvironment(config *EnvironmentConfig, entries ...*ServiceEntry) *SharedEnvironment {
+	return &SharedEnvironment{config: config, entries: entries}
+}
+
+// WithReset sets the hook run on each Acquire of the already started environment, so that tests start from a clean
+// state without restarting the containers. It is not run for the Acquire that starts the environment
+func (s *SharedEnvironment) WithReset(reset ResetHandler) *SharedEnvironment {
+	s.reset = reset
+	return s
+}
+
+// Acquire returns the environment, starting it if it isn't up, and takes a reference to it. Every successful Acquire
+// must be paired with a Release
+func (s *SharedEnvironment) Acquire(ctx context.Context) (*Environment, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.env == nil {
+		env, err := StartEnvironmentContext(ctx, s.config, s.entries...)
+		if err != nil {
+			return nil, err
+		}
+		s.env = env
+	} else if s.reset != nil {
+		if err := s.reset(s.env); err != nil {
+			return nil, fmt.Errorf("could not reset shared environment: %w", err)
+		}
+	}
+	s.refs++
+	return s.env, nil
+}
+
+// AcquireT like Acquire, but fails the test if the environment can't be acquired, and releases it when the test and its
+// subtests complete
+func (s *SharedEnvironment) AcquireT(tb TB) *Environment {
+	tb.Helper()
+	env, err := s.Acquire(context.Background())
+	if err != nil {
+		tb.Fatalf("could not acquire shared environment: %v", err)
+	}
+	tb.Cleanup(s.Release)
+	return env
+}
+
+// Release drops a reference to the environment, shutting it down if it was the last one
+func (s *SharedEnvironment) Release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.refs == 0 {
+		globalLogger().Warnf("shared environment released more times than acquired")
+		return
+	}
+	s.refs--
+	if s.refs == 0 {
+		s.env.Shutdown()
+		s.env = nil
+	}
+}
@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+type (
+	// environmentRegistry the live environments, shut down on SIGINT/SIGTERM once HandleSignals was called
+	environmentRegistry struct {
+		lock sync.Mutex
+		envs map[*Environment]struct{}
+		// interrupted closed on the first signal, to abort the environments being started
+		interrupted chan struct{}
+		once        sync.Once
+	}
+)
+
+var registry = &environmentRegistry{
+	envs:        make(map[*Environment]struct{}),
+	interrupted: make(chan struct{}),
+}
+
+// HandleSignals shuts down all live environments (running their shutdown hooks, Down and After handlers) on the
+// first SIGINT or SIGTERM, then exits. A second signal exits right away. Environments started with NoShutdown are left
+// running. Typically called from TestMain
+func HandleSignals() {
+	registry.once.Do(func() {
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go registry.handle(signals)
+	})
+}
+
+func (r *environmentRegistry) handle(signals <-chan os.Signal) {
+	sig := <-signals
+	globalLogger().Warnf("received %s. shutting down environments (signal again to force exit)", sig)
+	close(r.interrupted)
+	go func() {
+		sig := <-signals
+		globalLogger().Errorf("received %s. exiting without shutting down", sig)
+		os.Exit(1)
+	}()
+	r.lock.Lock()
+	var envs []*Environment
+	for env := range r.envs {
+		envs = append(envs, env)
+	}
+	r.lock.Unlock()
+	pool := new(sync.WaitGroup)
+	pool.Add(len(envs))
+	for _, env := range envs {
+		env := env
+		go func() {
+			defer pool.Done()
+			env.Shutdown()
+		}()
+	}
+	pool.Wait()
+	os.Exit(1)
+}
+
+func (r *environmentRegistry) add(env *Environment) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.envs[env] = struct{}{}
+}
+
+func (r *environmentRegistry) remove(env *Environment) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.envs, env)
+}
+
+// withInterrupt derives a context that is also done on the first signal handled by HandleSignals
+func (r *environmentRegistry) withInterrupt(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-r.interrupted:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
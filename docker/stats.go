@@ -0,0 +1,232 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+type (
+	// ContainerStats a snapshot of a container's resource usage (see docker stats)
+	ContainerStats struct {
+		Time time.Time `json:"time"`
+		// CPUPercent the CPU usage since the previous snapshot, where 100 is one CPU fully used
+		CPUPercent float64 `json:"cpuPercent"`
+		// CPUTime the total CPU time used by the container since it started
+		CPUTime time.Duration `json:"cpuTime"`
+		// MemoryUsage the memory used, excluding the inactive page cache (like docker stats)
+		MemoryUsage uint64 `json:"memoryUsage"`
+		MemoryLimit uint64 `json:"memoryLimit"`
+		// NetworkRx/NetworkTx the bytes received/sent over all interfaces
+		NetworkRx uint64 `json:"networkRx"`
+		NetworkTx uint64 `json:"networkTx"`
+		// BlockRead/BlockWrite the bytes read from/written to block devices
+		BlockRead  uint64 `json:"blockRead"`
+		BlockWrite uint64 `json:"blockWrite"`
+		PIDs       uint64 `json:"pids"`
+	}
+
+	// StatsSummary aggregates the snapshots taken by a StatsRecorder
+	StatsSummary struct {
+		Container string        `json:"container"`
+		Samples   int           `json:"samples"`
+		Duration  time.Duration `json:"duration"`
+		// PeakMemory/AvgMemory the highest/average memory usage of the samples
+		PeakMemory uint64 `json:"peakMemory"`
+		AvgMemory  uint64 `json:"avgMemory"`
+		// CPUSeconds the CPU time used between the first and last samples
+		CPUSeconds float64 `json:"cpuSeconds"`
+		// PeakCPUPercent the highest CPU usage of the samples
+		PeakCPUPercent float64 `json:"peakCpuPercent"`
+		// NetworkRx/NetworkTx the bytes received/sent between the first and last samples
+		NetworkRx uint64 `json:"networkRx"`
+		NetworkTx uint64 `json:"networkTx"`
+		// Errors the number of failed samples
+		Errors int `json:"errors,omitempty"`
+	}
+
+	// StatsRecorder samples a container's resource usage at an interval, until stopped. See Container.StartSampling
+	StatsRecorder struct {
+		cntr     *Container
+		interval time.Duration
+		lock     sync.Mutex
+		samples  []ContainerStats
+		errors   int
+		cancel   context.CancelFunc
+		done     chan struct{}
+	}
+
+	// statsRecorders the recorders of an environment, stopped and summarized on shutdown
+	statsRecorders struct {
+		lock      sync.Mutex
+		recorders []*StatsRecorder
+	}
+)
+
+// Stats a snapshot of the container's resource usage. Takes about a second, for the Docker daemon to measure the CPU
+// usage
+func (c *Container) Stats(ctx context.Context) (*ContainerStats, error) {
+	resp, err := c.cli.ContainerStats(ctx, c.Config.ID, false)
+	if err != nil {
+		return nil, &EngineError{Op: "get stats of", Resource: "container", Name: c.Config.Names[0], Err: err}
+	}
+	defer resp.Body.Close()
+	var raw container.StatsResponse
+	if err = json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("could not decode stats of container %s: %w", c.Config.Names[0], err)
+	}
+	return newContainerStats(&raw), nil
+}
+
+func newContainerStats(raw *container.StatsResponse) *ContainerStats {
+	stats := &ContainerStats{
+		Time:        raw.Read,
+		CPUTime:     time.Duration(raw.CPUStats.CPUUsage.TotalUsage),
+		MemoryUsage: raw.MemoryStats.Usage,
+		MemoryLimit: raw.MemoryStats.Limit,
+		PIDs:        raw.PidsStats.Current,
+	}
+	// same as the docker CLI: cgroup v1 and v2 name the inactive cache differently
+	if cache, ok := raw.MemoryStats.Stats["total_inactive_file"]; ok && cache < stats.MemoryUsage {
+		stats.MemoryUsage -= cache
+	} else if cache, ok = raw.MemoryStats.Stats["inactive_file"]; ok && cache < stats.MemoryUsage {
+		stats.MemoryUsage -= cache
+	}
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+	cpus := float64(raw.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(raw.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+	for _, network := range raw.Networks {
+		stats.NetworkRx += network.RxBytes
+		stats.NetworkTx += network.TxBytes
+	}
+	for _, entry := range raw.BlkioStats.IoServiceBytesRecursive {
+		switch entry.Op {
+		case "read", "Read":
+			stats.BlockRead += entry.Value
+		case "write", "Write":
+			stats.BlockWrite += entry.Value
+		}
+	}
+	return stats
+}
+
+// StartSampling records the container's resource usage every interval until Stop is called, or the environment is shut
+// down. Intervals under a second are raised to a second, the time a snapshot takes (see Stats). The summary is then
+// included in the environment summary artifact (see EnvironmentConfig.ArtifactsDir)
+func (c *Container) StartSampling(interval time.Duration) *StatsRecorder {
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &StatsRecorder{cntr: c, interval: interval, cancel: cancel, done: make(chan struct{})}
+	if c.sampling != nil {
+		c.sampling.add(r)
+	}
+	go r.run(ctx)
+	return r
+}
+
+func (r *StatsRecorder) run(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		stats, err := r.cntr.Stats(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		r.lock.Lock()
+		if err != nil {
+			r.errors++
+		} else {
+			r.samples = append(r.samples, *stats)
+		}
+		r.lock.Unlock()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop stops sampling, and returns the summary
+func (r *StatsRecorder) Stop() StatsSummary {
+	r.cancel()
+	<-r.done
+	return r.Summary()
+}
+
+// Samples the snapshots taken so far
+func (r *StatsRecorder) Samples() []ContainerStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]ContainerStats(nil), r.samples...)
+}
+
+// Summary aggregates the snapshots taken so far
+func (r *StatsRecorder) Summary() StatsSummary {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	summary := StatsSummary{Container: containerName(*r.cntr.Config), Samples: len(r.samples), Errors: r.errors}
+	if len(r.samples) == 0 {
+		return summary
+	}
+	first, last := r.samples[0], r.samples[len(r.samples)-1]
+	summary.Duration = last.Time.Sub(first.Time)
+	summary.CPUSeconds = (last.CPUTime - first.CPUTime).Seconds()
+	if last.NetworkRx >= first.NetworkRx && last.NetworkTx >= first.NetworkTx {
+		summary.NetworkRx, summary.NetworkTx = last.NetworkRx-first.NetworkRx, last.NetworkTx-first.NetworkTx
+	}
+	var totalMemory uint64
+	for _, sample := range r.samples {
+		totalMemory += sample.MemoryUsage
+		if sample.MemoryUsage > summary.PeakMemory {
+			summary.PeakMemory = sample.MemoryUsage
+		}
+		if sample.CPUPercent > summary.PeakCPUPercent {
+			summary.PeakCPUPercent = sample.CPUPercent
+		}
+	}
+	summary.AvgMemory = totalMemory / uint64(len(r.samples))
+	return summary
+}
+
+func (s *statsRecorders) add(r *StatsRecorder) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.recorders = append(s.recorders, r)
+}
+
+// stop stops all recorders, and returns their summaries
+func (s *statsRecorders) stop() []StatsSummary {
+	s.lock.Lock()
+	recorders := s.recorders
+	s.lock.Unlock()
+	var summaries []StatsSummary
+	for _, r := range recorders {
+		summaries = append(summaries, r.Stop())
+	}
+	return summaries
+}
+
+// summaries the recorders' summaries, by container ID
+func (s *statsRecorders) summaries() map[string][]StatsSummary {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	summaries := make(map[string][]StatsSummary)
+	for _, r := range s.recorders {
+		summaries[r.cntr.Config.ID] = append(summaries[r.cntr.Config.ID], r.Summary())
+	}
+	return summaries
+}
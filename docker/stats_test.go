@@ -0,0 +1,186 @@
+package docker
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestNewContainerStats(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		raw  container.StatsResponse
+		want ContainerStats
+	}{
+		{
+			name: "cgroup v1",
+			raw: container.StatsResponse{
+				Read: now,
+				CPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 3_000_000_000, PercpuUsage: []uint64{1, 2}},
+					SystemUsage: 20_000_000_000,
+				},
+				PreCPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 2_000_000_000},
+					SystemUsage: 10_000_000_000,
+				},
+				MemoryStats: container.MemoryStats{
+					Usage: 1000,
+					Limit: 4000,
+					Stats: map[string]uint64{"total_inactive_file": 300, "inactive_file": 100},
+				},
+				PidsStats: container.PidsStats{Current: 7},
+				Networks: map[string]container.NetworkStats{
+					"eth0": {RxBytes: 10, TxBytes: 20},
+					"eth1": {RxBytes: 1, TxBytes: 2},
+				},
+				BlkioStats: container.BlkioStats{IoServiceBytesRecursive: []container.BlkioStatEntry{
+					{Op: "Read", Value: 100},
+					{Op: "Write", Value: 200},
+					{Op: "Read", Value: 1},
+					{Op: "Total", Value: 301},
+				}},
+			},
+			want: ContainerStats{
+				Time:        now,
+				CPUPercent:  20, // a tenth of the system time, over 2 CPUs
+				CPUTime:     3 * time.Second,
+				MemoryUsage: 700,
+				MemoryLimit: 4000,
+				NetworkRx:   11,
+				NetworkTx:   22,
+				BlockRead:   101,
+				BlockWrite:  200,
+				PIDs:        7,
+			},
+		},
+		{
+			name: "cgroup v2",
+			raw: container.StatsResponse{
+				Read: now,
+				CPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 1_500_000_000},
+					SystemUsage: 20_000_000_000,
+					OnlineCPUs:  4,
+				},
+				PreCPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 1_000_000_000},
+					SystemUsage: 10_000_000_000,
+				},
+				MemoryStats: container.MemoryStats{Usage: 1000, Stats: map[string]uint64{"inactive_file": 100}},
+				BlkioStats: container.BlkioStats{IoServiceBytesRecursive: []container.BlkioStatEntry{
+					{Op: "read", Value: 5},
+					{Op: "write", Value: 6},
+				}},
+			},
+			want: ContainerStats{
+				Time:        now,
+				CPUPercent:  20,
+				CPUTime:     1500 * time.Millisecond,
+				MemoryUsage: 900,
+				BlockRead:   5,
+				BlockWrite:  6,
+			},
+		},
+		{
+			name: "cache over the usage",
+			raw: container.StatsResponse{
+				MemoryStats: container.MemoryStats{Usage: 100, Stats: map[string]uint64{"total_inactive_file": 200}},
+			},
+			want: ContainerStats{MemoryUsage: 100},
+		},
+		{
+			name: "first sample",
+			raw: container.StatsResponse{
+				CPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 1_000_000_000},
+					SystemUsage: 10_000_000_000,
+					OnlineCPUs:  1,
+				},
+			},
+			want: ContainerStats{CPUTime: time.Second, CPUPercent: 10},
+		},
+		{
+			name: "no system time",
+			raw: container.StatsResponse{
+				CPUStats:    container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 2}, SystemUsage: 5, OnlineCPUs: 1},
+				PreCPUStats: container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 1}, SystemUsage: 5},
+			},
+			want: ContainerStats{CPUTime: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := *newContainerStats(&tt.raw)
+			if math.Abs(got.CPUPercent-tt.want.CPUPercent) > 1e-9 {
+				t.Errorf("CPU percent %v, want %v", got.CPUPercent, tt.want.CPUPercent)
+			}
+			got.CPUPercent = tt.want.CPUPercent
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsRecorder_Summary(t *testing.T) {
+	start := time.Now()
+	cntr := &Container{Config: &container.Summary{ID: "id", Names: []string{"/project-redis-1"}}}
+	tests := []struct {
+		name    string
+		samples []ContainerStats
+		errors  int
+		want    StatsSummary
+	}{
+		{
+			name:   "no sample",
+			errors: 2,
+			want:   StatsSummary{Container: "project-redis-1", Errors: 2},
+		},
+		{
+			name:    "single sample",
+			samples: []ContainerStats{{Time: start, CPUPercent: 5, CPUTime: time.Second, MemoryUsage: 100, NetworkRx: 10}},
+			want:    StatsSummary{Container: "project-redis-1", Samples: 1, PeakMemory: 100, AvgMemory: 100, PeakCPUPercent: 5},
+		},
+		{
+			name: "samples",
+			samples: []ContainerStats{
+				{Time: start, CPUPercent: 5, CPUTime: time.Second, MemoryUsage: 100, NetworkRx: 10, NetworkTx: 1},
+				{Time: start.Add(time.Second), CPUPercent: 50, CPUTime: 1500 * time.Millisecond, MemoryUsage: 400, NetworkRx: 20, NetworkTx: 5},
+				{Time: start.Add(3 * time.Second), CPUPercent: 10, CPUTime: 3 * time.Second, MemoryUsage: 200, NetworkRx: 30, NetworkTx: 11},
+			},
+			errors: 1,
+			want: StatsSummary{
+				Container:      "project-redis-1",
+				Samples:        3,
+				Duration:       3 * time.Second,
+				PeakMemory:     400,
+				AvgMemory:      233,
+				CPUSeconds:     2,
+				PeakCPUPercent: 50,
+				NetworkRx:      20,
+				NetworkTx:      10,
+				Errors:         1,
+			},
+		},
+		{
+			name: "network counters reset",
+			samples: []ContainerStats{
+				{Time: start, NetworkRx: 30, NetworkTx: 30},
+				{Time: start.Add(time.Second), NetworkRx: 10, NetworkTx: 40},
+			},
+			want: StatsSummary{Container: "project-redis-1", Samples: 2, Duration: time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &StatsRecorder{cntr: cntr, samples: tt.samples, errors: tt.errors}
+			if got := r.Summary(); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
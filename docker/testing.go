@@ -0,0 +1,46 @@
+package docker
+
+import "context"
+
+// TB the subset of testing.TB used to bind environments and loggers to a test, which keeps the testing package out of
+// the binaries importing this one. *testing.T and *testing.B implement it
+type TB interface {
+	Helper()
+	Cleanup(func())
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+	FailNow()
+	Failed() bool
+	Log(args ...interface{})
+	Logf(format string, args ...interface{})
+}
+
+// StartEnvironmentT starts the environment for the test, failing it if the environment can't be started. The
+// environment is shut down automatically when the test and its subtests complete. The services' logs and states are
+// only logged (through the test) if the test failed
+func StartEnvironmentT(tb TB, config *EnvironmentConfig, entries ...*ServiceEntry) *Environment {
+	tb.Helper()
+	env, err := startEnvironment(context.Background(), tb, config, entries...)
+	if err != nil {
+		tb.FailNow()
+	}
+	tb.Cleanup(env.Shutdown)
+	return env
+}
+
+// logDiagnostics logs the container's logs and state through the test
+func logDiagnostics(tb TB, config *ServiceEntry, container *Container) {
+	name := container.Config.Names[0]
+	if !config.DisableShutdownLogs {
+		if logs, err := container.Logs(); err != nil {
+			tb.Logf("couldn't get logs for service=%s: %v", name, err)
+		} else {
+			tb.Logf("============================%s logs============================\n%s", name, logs)
+		}
+	}
+	if state, err := container.State(); err != nil {
+		tb.Logf("couldn't get state for service=%s: %v", name, err)
+	} else {
+		tb.Logf("============================%s state============================\n%s", name, state)
+	}
+}
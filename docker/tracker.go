@@ -0,0 +1,284 @@
+package docker
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+type (
+	// stateTracker keeps an in-memory view of the state of a project's containers, fed by the Docker events stream.
+	// Once the stream breaks, the tracker reports itself as broken and consumers fall back to polling the daemon
+	stateTracker struct {
+		cli     *client.Client
+		log     Logger
+		filters filters.Args
+		cancel  context.CancelFunc
+		lock    sync.Mutex
+		// containers maps container IDs to their last known state
+		containers map[string]*trackedState
+		// changed is closed (and replaced) whenever the view changes or the tracker breaks
+		changed chan struct{}
+		broken  bool
+	}
+	trackedState struct {
+		service  string
+		running  bool
+		paused   bool
+		exited   bool
+		exitCode int
+		// health the health-check status: "" if the container has none, otherwise "starting", "healthy" or
+		// "unhealthy"
+		health string
+		// startedAt when the container last started (in Unix nanoseconds, by the daemon's clock). The events older
+		// than that, e.g. the die event of a restart, are stale
+		startedAt int64
+	}
+)
+
+// newStateTracker subscribes to the container events matching the labels and seeds the view with the existing
+// containers
+func newStateTracker(cli *client.Client, log Logger, labels ...string) *stateTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	args := filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)))
+	for _, label := range labels {
+		args.Add("label", label)
+	}
+	t := &stateTracker{
+		cli:        cli,
+		log:        log,
+		filters:    args,
+		cancel:     cancel,
+		containers: make(map[string]*trackedState),
+		changed:    make(chan struct{}),
+	}
+	// subscribe before listing so that no transition is missed in between
+	messages, errs := cli.Events(ctx, events.ListOptions{Filters: args})
+	if err := t.seed(ctx); err != nil {
+		t.log.Warnf("could not list containers for state tracking, falling back to polling: %v", err)
+		cancel()
+		t.breakDown()
+		return t
+	}
+	go t.listen(ctx, messages, errs)
+	return t
+}
+
+// close stops listening to events. The tracker reports itself as broken afterwards
+func (t *stateTracker) close() {
+	t.cancel()
+	t.breakDown()
+}
+
+// changes returns a channel that is closed on the next change of the view
+func (t *stateTracker) changes() <-chan struct{} {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.changed
+}
+
+// isBroken true if the view can no longer be trusted to be up-to-date
+func (t *stateTracker) isBroken() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.broken
+}
+
+// pollFallback returns a channel that fires after the poll interval if the tracker is broken, and nil otherwise
+func (t *stateTracker) pollFallback() <-chan time.Time {
+	if t.isBroken() {
+		return time.After(DefaultPollInterval)
+	}
+	return nil
+}
+
+// state the last known state of the container, and false if it is unknown or the tracker is broken
+func (t *stateTracker) state(id string) (trackedState, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	state, ok := t.containers[id]
+	if !ok || t.broken {
+		return trackedState{}, false
+	}
+	return *state, true
+}
+
+// hasContainer true if the service has at least one known container
+func (t *stateTracker) hasContainer(service string) bool {
+	return t.countContainers(service, false) > 0
+}
+
+// hasRunning true if the service has at least one known running container
+func (t *stateTracker) hasRunning(service string) bool {
+	return t.countContainers(service, true) > 0
+}
+
+func (t *stateTracker) countContainers(service string, runningOnly bool) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	count := 0
+	for _, state := range t.containers {
+		if state.service == service && (!runningOnly || state.running) {
+			count++
+		}
+	}
+	return count
+}
+
+// status the container status derived from the state, or nil if inspecting the container is needed for the details
+// (i.e. for error states)
+func (s trackedState) status() *ContainerStatus {
+	if !s.running {
+		if s.exitCode != 0 {
+			return nil
+		}
+		if s.exited {
+			return &ContainerStatus{Code: Exited}
+		}
+		return &ContainerStatus{Code: NotReady}
+	}
+	switch s.health {
+	case "", "healthy":
+		return &ContainerStatus{Code: Running}
+	case "unhealthy":
+		return nil
+	}
+	return &ContainerStatus{Code: NotReady}
+}
+
+func (t *stateTracker) seed(ctx context.Context) error {
+	list, err := t.cli.ContainerList(ctx, container.ListOptions{All: true, Filters: labelFilters(t.filters)})
+	if err != nil {
+		return err
+	}
+	for _, summary := range list {
+		if err = t.refresh(ctx, summary.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *stateTracker) listen(ctx context.Context, messages <-chan events.Message, errs <-chan error) {
+	for {
+		select {
+		case msg := <-messages:
+			t.apply(ctx, msg)
+		case err := <-errs:
+			if ctx.Err() == nil {
+				t.log.Warnf("docker events stream broke, falling back to polling: %v", err)
+			}
+			t.breakDown()
+			return
+		}
+	}
+}
+
+// apply updates the view with the event
+func (t *stateTracker) apply(ctx context.Context, msg events.Message) {
+	id := msg.Actor.ID
+	action := string(msg.Action)
+	switch {
+	case msg.Action == events.ActionStart || msg.Action == events.ActionCreate:
+		// (re)read the full state, as the events don't say whether the container has a health-check
+		if err := t.refresh(ctx, id); err != nil && ctx.Err() == nil {
+			t.log.Warnf("could not inspect container %s for state tracking: %v", id, err)
+			t.breakDown()
+		}
+		return
+	case strings.HasPrefix(action, string(events.ActionHealthStatus)):
+		status := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(action, string(events.ActionHealthStatus)), ":"))
+		t.update(id, msg, func(state *trackedState) {
+			state.health = status
+		})
+	case msg.Action == events.ActionDie:
+		t.update(id, msg, func(state *trackedState) {
+			state.running = false
+			state.exited = true
+			state.exitCode, _ = strconv.Atoi(msg.Actor.Attributes["exitCode"])
+		})
+	case msg.Action == events.ActionPause || msg.Action == events.ActionUnPause:
+		t.update(id, msg, func(state *trackedState) {
+			state.paused = msg.Action == events.ActionPause
+		})
+	case msg.Action == events.ActionDestroy:
+		t.lock.Lock()
+		delete(t.containers, id)
+		t.notify()
+		t.lock.Unlock()
+	}
+}
+
+// refresh inspects the container and replaces its state in the view
+func (t *stateTracker) refresh(ctx context.Context, id string) error {
+	inspection, err := t.cli.ContainerInspect(ctx, id)
+	if client.IsErrNotFound(err) {
+		return nil // already gone, its destroy event follows
+	} else if err != nil {
+		return err
+	}
+	state := &trackedState{
+		service:  inspection.Config.Labels[ServiceLabel],
+		running:  inspection.State.Running,
+		paused:   inspection.State.Paused,
+		exited:   strings.ToLower(inspection.State.Status) == "exited",
+		exitCode: inspection.State.ExitCode,
+	}
+	if inspection.State.Health != nil {
+		state.health = strings.ToLower(inspection.State.Health.Status)
+	}
+	if startedAt, err := time.Parse(time.RFC3339Nano, inspection.State.StartedAt); err == nil {
+		state.startedAt = startedAt.UnixNano()
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.containers[id] = state
+	t.notify()
+	return nil
+}
+
+func (t *stateTracker) update(id string, msg events.Message, fn func(state *trackedState)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	state, ok := t.containers[id]
+	if !ok {
+		state = &trackedState{service: msg.Actor.Attributes[ServiceLabel]}
+		t.containers[id] = state
+	} else if msg.TimeNano < state.startedAt {
+		return
+	}
+	fn(state)
+	t.notify()
+}
+
+func (t *stateTracker) breakDown() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.broken {
+		return
+	}
+	t.broken = true
+	t.notify()
+}
+
+// notify wakes up the consumers waiting on changes. Must be called with the lock held
+func (t *stateTracker) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// labelFilters the label filters of the args, which are all that a container listing needs
+func labelFilters(args filters.Args) filters.Args {
+	labels := filters.NewArgs()
+	for _, label := range args.Get("label") {
+		labels.Add("label", label)
+	}
+	return labels
+}
@@ -0,0 +1,42 @@
+package docker
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// TypedHandler adapts a handler with a concrete output type for use as a ServiceEntry.Handler, so that its output
+// can be read back with Get
+func TypedHandler[T any](handler func(*Container) (T, error)) ServiceHandler {
+	return func(container *Container) (interface{}, error) {
+		return handler(container)
+	}
+}
+
+// Get returns the output of the service's handler (see Environment.Services) as a T, or an error if the service has
+// no output (including a typed nil, e.g. a nil *redis.Client) or its output is not a T
+func Get[T any](env *Environment, service string) (T, error) {
+	var zero T
+	output, ok := env.Services[service]
+	if !ok {
+		return zero, fmt.Errorf("service %s is not running in the environment", service)
+	}
+	if output == nil || isNil(reflect.ValueOf(output)) {
+		return zero, fmt.Errorf("service %s has no handler output", service)
+	}
+	typed, ok := output.(T)
+	if !ok {
+		return zero, fmt.Errorf("handler output of service %s is a %T, not a %s", service, output, reflect.TypeOf((*T)(nil)).Elem())
+	}
+	return typed, nil
+}
+
+// isNil whether the value is a nil pointer, map, slice, channel or function, which a nil check of the interface
+// holding it misses
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
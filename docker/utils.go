@@ -2,6 +2,7 @@ package docker
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/docker/docker/api/types/container"
@@ -90,9 +91,9 @@ func ReadEnvFile(path string) (map[string]string, error) {
 
 }
 
+// ColoredPrintf prints the message through the global Logger (see SetLogger)
 func ColoredPrintf(color Color, msg string) {
-	colored := string(color) + strings.ReplaceAll(msg, "\n", "\n"+string(color)) + string(ColorReset)
-	fmt.Println(colored)
+	globalLogger().Print(color, msg)
 }
 
 // IsEmpty for whatever reason they don't like to add a simple Size()/Length() method to this...
@@ -105,25 +106,29 @@ func IsEmpty(m *sync.Map) bool {
 	return empty
 }
 
+// PrintLogs prints the container's logs through its environment's Logger
 func PrintLogs(color Color, container *Container) {
+	log := container.logger()
 	logs, err := container.Logs()
 	if err != nil {
-		logger.Errorf("Couldn't get logs for service=%s", container.Config.Names[0])
+		log.Errorf("Couldn't get logs for service=%s", container.Config.Names[0])
 	} else {
-		ColoredPrintf(color, fmt.Sprintf("============================%s logs============================\n", container.Config.Names[0]))
-		ColoredPrintf(color, logs)
-		ColoredPrintf(color, "===============================================================\n")
+		log.Print(color, fmt.Sprintf("============================%s logs============================\n", container.Config.Names[0]))
+		log.Print(color, logs)
+		log.Print(color, "===============================================================\n")
 	}
 }
 
+// PrintContainerState prints the container's state through its environment's Logger
 func PrintContainerState(color Color, container *Container) {
+	log := container.logger()
 	state, err := container.State()
 	if err != nil {
-		logger.Errorf("Couldn't get logs for service=%s", container.Config.Names[0])
+		log.Errorf("Couldn't get logs for service=%s", container.Config.Names[0])
 	} else {
-		ColoredPrintf(color, fmt.Sprintf("============================%s state============================\n", container.Config.Names[0]))
-		ColoredPrintf(color, state)
-		ColoredPrintf(color, "================================================================\n")
+		log.Print(color, fmt.Sprintf("============================%s state============================\n", container.Config.Names[0]))
+		log.Print(color, state)
+		log.Print(color, "================================================================\n")
 	}
 }
 
@@ -171,29 +176,24 @@ func parsePorts(ports []container.Port) (map[int][]int, error) {
 	return portMap, nil
 }
 
-func runCommand(cmd *exec.Cmd, timeout ...time.Duration) error {
+// runCommand runs the command to completion, printing its output. The command must have been created with
+// exec.CommandContext using ctx, so that it is killed once ctx is done
+func runCommand(ctx context.Context, log Logger, cmd *exec.Cmd) error {
+	// don't hang on the output pipes of grandchildren that outlive a killed process
+	cmd.WaitDelay = time.Second
 	if err := RunProcessWithLogs(cmd, func(msg string) {
-		ColoredPrintf(GREEN, msg)
+		log.Print(GREEN, msg)
 	}); err != nil {
 		return err
 	}
-	if len(timeout) == 0 {
-		return cmd.Wait()
-	}
-	waiter := time.After(timeout[0])
-	done := make(chan error)
-	go func() {
-		done <- cmd.Wait()
-	}()
-	select {
-	case <-waiter:
-		return fmt.Errorf("process did not complete within the timeout\n%s", string(debug.Stack()))
-	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("process returned error: %w\n%s", err, string(debug.Stack()))
-		}
-		return nil
+	err := cmd.Wait()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("process did not complete: %w\n%s", ctxErr, string(debug.Stack()))
+	}
+	if err != nil {
+		return fmt.Errorf("process returned error: %w\n%s", err, string(debug.Stack()))
 	}
+	return nil
 }
 
 type ContainerStatusCode uint8
@@ -215,6 +215,9 @@ type (
 	Endpoints interface {
 		GetHost() string
 		GetPublicPorts(privatePorts ...int) []int
+		// Proxied starts a Proxy in front of each of the private ports (all of them if none given), for fault
+		// injection. See ProxiedEndpoints
+		Proxied(privatePorts ...int) (*ProxiedEndpoints, error)
 	}
 
 	endpoints struct {
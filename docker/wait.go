@@ -0,0 +1,311 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPollInterval the interval at which wait strategies re-check readiness
+const DefaultPollInterval = 500 * time.Millisecond
+
+type (
+	// WaitStrategy decides when a started container is ready to be handed to its service handler
+	WaitStrategy interface {
+		// WaitUntilReady blocks until the container is ready, or returns an error once ctx is done
+		WaitUntilReady(ctx context.Context, container *Container) error
+	}
+
+	// PortStrategy waits until a TCP connection can be made to a published port
+	PortStrategy struct {
+		port         int
+		pollInterval time.Duration
+	}
+	// HTTPStrategy waits until an HTTP endpoint on a published port responds as expected
+	HTTPStrategy struct {
+		port          int
+		path          string
+		method        string
+		https         bool
+		statusMatcher func(status int) bool
+		bodyPattern   *regexp.Regexp
+		pollInterval  time.Duration
+	}
+	// LogStrategy waits until a log line matching a pattern appears a number of times
+	LogStrategy struct {
+		pattern     *regexp.Regexp
+		occurrences int
+	}
+	// ExecStrategy waits until a command run inside the container exits with code 0
+	ExecStrategy struct {
+		cmd          []string
+		pollInterval time.Duration
+	}
+	// HealthStrategy waits until the container's health-check reports healthy
+	HealthStrategy struct {
+		pollInterval time.Duration
+	}
+	// allStrategy waits for all of its strategies
+	allStrategy []WaitStrategy
+	// anyStrategy waits for the first of its strategies
+	anyStrategy []WaitStrategy
+)
+
+// ForListeningPort waits until the public port bound to the given private port accepts TCP connections
+func ForListeningPort(privatePort int) *PortStrategy {
+	return &PortStrategy{port: privatePort, pollInterval: DefaultPollInterval}
+}
+
+// ForHTTP waits until a GET on the path of the public port bound to the given private port returns a 2xx status
+func ForHTTP(privatePort int, path string) *HTTPStrategy {
+	return &HTTPStrategy{
+		port:   privatePort,
+		path:   path,
+		method: http.MethodGet,
+		statusMatcher: func(status int) bool {
+			return status >= 200 && status < 300
+		},
+		pollInterval: DefaultPollInterval,
+	}
+}
+
+// ForLog waits until the container's logs contain a line matching pattern
+func ForLog(pattern *regexp.Regexp) *LogStrategy {
+	return &LogStrategy{pattern: pattern, occurrences: 1}
+}
+
+// ForExec waits until the command (argv form, no shell) exits with code 0 inside the container
+func ForExec(cmd ...string) *ExecStrategy {
+	return &ExecStrategy{cmd: cmd, pollInterval: DefaultPollInterval}
+}
+
+// ForHealthy waits until the container's health-check reports healthy. The image or compose file must define one
+func ForHealthy() *HealthStrategy {
+	return &HealthStrategy{pollInterval: DefaultPollInterval}
+}
+
+// ForAll waits until every strategy is satisfied
+func ForAll(strategies ...WaitStrategy) WaitStrategy {
+	return allStrategy(strategies)
+}
+
+// ForAny waits until at least one of the strategies is satisfied
+func ForAny(strategies ...WaitStrategy) WaitStrategy {
+	return anyStrategy(strategies)
+}
+
+// WithPollInterval sets how often the port is dialed
+func (s *PortStrategy) WithPollInterval(interval time.Duration) *PortStrategy {
+	s.pollInterval = interval
+	return s
+}
+
+func (s *PortStrategy) WaitUntilReady(ctx context.Context, container *Container) error {
+	dialer := new(net.Dialer)
+	return poll(ctx, s.pollInterval, func() error {
+		address, err := container.publicAddress(s.port)
+		if err != nil {
+			return err
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// WithMethod sets the HTTP method of the request
+func (s *HTTPStrategy) WithMethod(method string) *HTTPStrategy {
+	s.method = method
+	return s
+}
+
+// WithTLS makes the request over HTTPS, without verifying the server's certificate
+func (s *HTTPStrategy) WithTLS() *HTTPStrategy {
+	s.https = true
+	return s
+}
+
+// WithStatus expects the response status to be one of the given codes
+func (s *HTTPStrategy) WithStatus(codes ...int) *HTTPStrategy {
+	return s.WithStatusMatcher(func(status int) bool {
+		for _, code := range codes {
+			if status == code {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// WithStatusMatcher expects the response status to satisfy the matcher
+func (s *HTTPStrategy) WithStatusMatcher(matcher func(status int) bool) *HTTPStrategy {
+	s.statusMatcher = matcher
+	return s
+}
+
+// WithBody expects the response body to match the pattern
+func (s *HTTPStrategy) WithBody(pattern *regexp.Regexp) *HTTPStrategy {
+	s.bodyPattern = pattern
+	return s
+}
+
+// WithPollInterval sets how often the request is made
+func (s *HTTPStrategy) WithPollInterval(interval time.Duration) *HTTPStrategy {
+	s.pollInterval = interval
+	return s
+}
+
+func (s *HTTPStrategy) WaitUntilReady(ctx context.Context, container *Container) error {
+	scheme := "http"
+	httpClient := new(http.Client)
+	if s.https {
+		scheme = "https"
+		httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	return poll(ctx, s.pollInterval, func() error {
+		address, err := container.publicAddress(s.port)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, s.method, fmt.Sprintf("%s://%s/%s", scheme, address, strings.TrimPrefix(s.path, "/")), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if !s.statusMatcher(resp.StatusCode) {
+			return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL)
+		}
+		if s.bodyPattern == nil {
+			return nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if !s.bodyPattern.Match(body) {
+			return fmt.Errorf("response body from %s does not match %s", req.URL, s.bodyPattern)
+		}
+		return nil
+	})
+}
+
+// WithOccurrence sets how many matching lines are needed
+func (s *LogStrategy) WithOccurrence(occurrences int) *LogStrategy {
+	s.occurrences = occurrences
+	return s
+}
+
+func (s *LogStrategy) WaitUntilReady(ctx context.Context, container *Container) error {
+	_, err := container.WaitForLog(ctx, s.pattern, s.occurrences)
+	return err
+}
+
+// WithPollInterval sets how often the command is run
+func (s *ExecStrategy) WithPollInterval(interval time.Duration) *ExecStrategy {
+	s.pollInterval = interval
+	return s
+}
+
+func (s *ExecStrategy) WaitUntilReady(ctx context.Context, container *Container) error {
+	return poll(ctx, s.pollInterval, func() error {
+		_, err := container.ExecOrFail(ctx, ExecOptions{Cmd: s.cmd})
+		return err
+	})
+}
+
+// WithPollInterval sets how often the health status is checked
+func (s *HealthStrategy) WithPollInterval(interval time.Duration) *HealthStrategy {
+	s.pollInterval = interval
+	return s
+}
+
+func (s *HealthStrategy) WaitUntilReady(ctx context.Context, container *Container) error {
+	return poll(ctx, s.pollInterval, func() error {
+		inspection, err := container.cli.ContainerInspect(ctx, container.Config.ID)
+		if err != nil {
+			return err
+		}
+		if inspection.State.Health == nil {
+			return errors.New("container has no health-check")
+		}
+		if status := inspection.State.Health.Status; status != "healthy" {
+			return fmt.Errorf("health status is %s", status)
+		}
+		return nil
+	})
+}
+
+func (s allStrategy) WaitUntilReady(ctx context.Context, container *Container) error {
+	for _, strategy := range s {
+		if err := strategy.WaitUntilReady(ctx, container); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s anyStrategy) WaitUntilReady(ctx context.Context, container *Container) error {
+	if len(s) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan error, len(s))
+	for _, strategy := range s {
+		strategy := strategy
+		go func() {
+			results <- strategy.WaitUntilReady(ctx, container)
+		}()
+	}
+	var errs []error
+	for range s {
+		err := <-results
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("no wait strategy was satisfied: %w", errors.Join(errs...))
+}
+
+// poll calls check every interval until it succeeds, returning the last failure once ctx is done
+func poll(ctx context.Context, interval time.Duration, check func() error) error {
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w. last error: %v", ctx.Err(), err)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// publicAddress the host:port address the given private port is published on
+func (c *Container) publicAddress(privatePort int) (string, error) {
+	endpoints, err := c.endpoints()
+	if err != nil {
+		return "", err
+	}
+	ports := endpoints.GetPublicPorts(privatePort)
+	if len(ports) == 0 {
+		return "", fmt.Errorf("private port %d of container %s is not published", privatePort, c.Config.Names[0])
+	}
+	return net.JoinHostPort(endpoints.GetHost(), strconv.Itoa(ports[0])), nil
+}
@@ -1,6 +1,20 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -10,13 +24,29 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	docker.RunReaperIfChild()
+	os.Exit(m.Run())
+}
+
+// testConfig completes the config of an integration test's environment: 30s timeouts, and the
+// docker-compose.tests.yml file unless others are set
+func testConfig(config docker.EnvironmentConfig) *docker.EnvironmentConfig {
+	if config.UpTimeout == 0 {
+		config.UpTimeout = 30 * time.Second
+	}
+	if config.DownTimeout == 0 {
+		config.DownTimeout = 30 * time.Second
+	}
+	if len(config.ComposeFilePaths) == 0 {
+		config.ComposeFilePaths = []string{"docker-compose.tests.yml"}
+	}
+	return &config
+}
+
 func TestRedis(t *testing.T) {
 	env, err := docker.StartEnvironment(
-		&docker.EnvironmentConfig{
-			UpTimeout:        30 * time.Second,
-			DownTimeout:      30 * time.Second,
-			ComposeFilePaths: []string{"docker-compose.tests.yml"},
-		},
+		testConfig(docker.EnvironmentConfig{}),
 		&docker.ServiceEntry{
 			Name:    "redis",
 			Handler: GetRedisClient,
@@ -33,11 +63,7 @@ func TestRedis(t *testing.T) {
 
 func TestRedis_ManualStartStop1(t *testing.T) {
 	env, err := docker.StartEnvironment(
-		&docker.EnvironmentConfig{
-			UpTimeout:        30 * time.Second,
-			DownTimeout:      30 * time.Second,
-			ComposeFilePaths: []string{"docker-compose.tests.yml"},
-		},
+		testConfig(docker.EnvironmentConfig{}),
 	)
 	require.NoError(t, err)
 	t.Cleanup(env.Shutdown)
@@ -66,11 +92,7 @@ func TestRedis_ManualStartStop1(t *testing.T) {
 
 func TestRedis_ManualStartStop2(t *testing.T) {
 	env, err := docker.StartEnvironment(
-		&docker.EnvironmentConfig{
-			UpTimeout:        30 * time.Second,
-			DownTimeout:      30 * time.Second,
-			ComposeFilePaths: []string{"docker-compose.tests.yml"},
-		},
+		testConfig(docker.EnvironmentConfig{}),
 		&docker.ServiceEntry{
 			Name:    "redis",
 			Handler: GetRedisClient,
@@ -115,11 +137,7 @@ func TestRedis_ContainerManipulation(t *testing.T) {
 		return container, nil
 	}
 	env, err := docker.StartEnvironment(
-		&docker.EnvironmentConfig{
-			UpTimeout:        30 * time.Second,
-			DownTimeout:      30 * time.Second,
-			ComposeFilePaths: []string{"docker-compose.tests.yml"},
-		},
+		testConfig(docker.EnvironmentConfig{}),
 		&docker.ServiceEntry{
 			Name:    "redis",
 			Handler: getContainer,
@@ -137,3 +155,839 @@ func TestRedis_ContainerManipulation(t *testing.T) {
 	require.Greater(t, len(output), 100) // lots of messages
 	require.Contains(t, output[0], "rm: can't remove")
 }
+
+func TestRedis_ParallelProjects(t *testing.T) {
+	for _, value := range []string{"first", "second"} {
+		value := value
+		t.Run(value, func(t *testing.T) {
+			t.Parallel()
+			env, err := docker.StartEnvironment(
+				testConfig(docker.EnvironmentConfig{
+					ComposeFilePaths:  []string{"docker-compose.isolated.yml"},
+					UniqueProjectName: true,
+				}),
+				&docker.ServiceEntry{
+					Name:    "redis",
+					Handler: GetRedisClient,
+				},
+			)
+			require.NoError(t, err)
+			t.Cleanup(env.Shutdown)
+			client := env.Services["redis"].(*redis.Client)
+			// each project has its own redis, so neither sees the other's key
+			require.NoError(t, client.SetNX("key", value, 0).Err())
+			cmd := client.Get("key")
+			require.NoError(t, cmd.Err())
+			require.Equal(t, value, cmd.Val())
+		})
+	}
+}
+
+func TestRedis_EngineBackend(t *testing.T) {
+	env, err := docker.StartEnvironment(
+		testConfig(docker.EnvironmentConfig{Backend: docker.BackendEngine}),
+		&docker.ServiceEntry{
+			Name:    "redis",
+			Handler: GetRedisClient,
+		},
+	)
+	require.NoError(t, err)
+	t.Cleanup(env.Shutdown)
+	client := env.Services["redis"].(*redis.Client)
+	client.Set("key", "value", 0)
+	cmd := client.Get("key")
+	require.NoError(t, cmd.Err())
+	require.Equal(t, "value", cmd.Val())
+	require.NoError(t, env.StopServices("redis"))
+	require.Error(t, client.Set("key2", "value", 0).Err())
+}
+
+func TestRedis_WaitFor(t *testing.T) {
+	env, err := docker.StartEnvironment(
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{
+			Name: "redis",
+			WaitFor: docker.ForAll(
+				docker.ForListeningPort(6379),
+				docker.ForLog(regexp.MustCompile("Ready to accept connections")),
+				docker.ForAny(docker.ForHealthy(), docker.ForExec("redis-cli", "ping")),
+			),
+			// no retries needed, the service is ready by the time the handler runs
+			Handler: func(container *docker.Container) (interface{}, error) {
+				endpoints, err := container.GetEndpoints()
+				if err != nil {
+					return nil, err
+				}
+				addr := fmt.Sprintf("%s:%d", endpoints.GetHost(), endpoints.GetPublicPorts(6379)[0])
+				client := redis.NewClient(&redis.Options{Addr: addr})
+				return client, client.Ping().Err()
+			},
+		},
+	)
+	require.NoError(t, err)
+	t.Cleanup(env.Shutdown)
+	client := env.Services["redis"].(*redis.Client)
+	require.NoError(t, client.Set("key", "value", 0).Err())
+}
+
+func TestRedis_Typed(t *testing.T) {
+	env, err := docker.StartEnvironment(
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{
+			Name: "redis",
+			Handler: docker.TypedHandler(func(container *docker.Container) (*redis.Client, error) {
+				client, err := GetRedisClient(container)
+				if err != nil {
+					return nil, err
+				}
+				return client.(*redis.Client), nil
+			}),
+		},
+	)
+	require.NoError(t, err)
+	t.Cleanup(env.Shutdown)
+	client, err := docker.Get[*redis.Client](env, "redis")
+	require.NoError(t, err)
+	require.NoError(t, client.Set("key", "value", 0).Err())
+	_, err = docker.Get[*docker.Container](env, "redis")
+	require.ErrorContains(t, err, "is a *redis.Client, not a *docker.Container")
+	_, err = docker.Get[*redis.Client](env, "fake")
+	require.Error(t, err)
+}
+
+func TestRedis_StartEnvironmentT(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{
+			Name:    "redis",
+			Handler: GetRedisClient,
+		},
+	)
+	client := env.Services["redis"].(*redis.Client)
+	require.NoError(t, client.Set("key", "value", 0).Err())
+}
+
+func TestRedis_OverlappingServiceNames(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{ComposeFilePaths: []string{"docker-compose.overlap.yml"}}),
+		&docker.ServiceEntry{
+			Name:    "redis",
+			Handler: GetRedisClient,
+		},
+		&docker.ServiceEntry{
+			Name:    "redis-replica",
+			Handler: GetRedisClient,
+		},
+	)
+	primary := env.Services["redis"].(*redis.Client)
+	replica := env.Services["redis-replica"].(*redis.Client)
+	require.NoError(t, primary.Set("key", "value", 0).Err())
+	require.NoError(t, docker.AwaitUntil(10*time.Second, 100*time.Millisecond, func() error {
+		return replica.Get("key").Err()
+	}))
+}
+
+func TestRedis_Replicas(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{ComposeFilePaths: []string{"docker-compose.isolated.yml"}}),
+		&docker.ServiceEntry{
+			Name:     "redis",
+			Replicas: 3,
+			WaitFor:  docker.ForListeningPort(6379),
+			ClusterHandler: func(containers []*docker.Container) (interface{}, error) {
+				var clients []*redis.Client
+				for _, container := range containers {
+					client, err := GetRedisClient(container)
+					if err != nil {
+						return nil, err
+					}
+					clients = append(clients, client.(*redis.Client))
+				}
+				return clients, nil
+			},
+		},
+	)
+	clients := env.Services["redis"].([]*redis.Client)
+	require.Len(t, clients, 3)
+	for _, client := range clients {
+		require.NoError(t, client.Ping().Err())
+	}
+	containers, err := env.GetContainers("redis")
+	require.NoError(t, err)
+	require.Len(t, containers, 3)
+	_, err = env.GetContainer("redis")
+	require.Error(t, err)
+}
+
+func TestRedis_ExecWithOptions(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{
+			Name: "redis",
+		},
+	)
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	ctx := context.Background()
+	// separated streams and exit code
+	result, err := container.ExecWithOptions(ctx, docker.ExecOptions{Shell: "echo out; echo err >&2; exit 3"})
+	require.NoError(t, err)
+	require.Equal(t, 3, result.ExitCode)
+	require.Equal(t, "out\n", result.Stdout)
+	require.Equal(t, "err\n", result.Stderr)
+	// argv form, stdin, env and working directory
+	result, err = container.ExecOrFail(ctx, docker.ExecOptions{
+		Cmd:        []string{"sh", "-c", "cat; echo $GREETING; pwd"},
+		Stdin:      strings.NewReader("piped\n"),
+		Env:        map[string]string{"GREETING": "hello"},
+		WorkingDir: "/tmp",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "piped\nhello\n/tmp\n", result.Stdout)
+	// failures are no longer swallowed
+	_, err = container.ExecOrFail(ctx, docker.ExecOptions{Cmd: []string{"rm", "-rf", "/"}})
+	var execErr *docker.ExecError
+	require.True(t, errors.As(err, &execErr))
+	require.NotZero(t, execErr.Result.ExitCode)
+	require.Contains(t, execErr.Result.Stderr, "rm: can't remove")
+}
+
+func TestRedis_SharedEnvironment(t *testing.T) {
+	shared := docker.NewSharedEnvironment(
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{
+			Name:    "redis",
+			Handler: GetRedisClient,
+		},
+	).WithReset(func(env *docker.Environment) error {
+		return env.Services["redis"].(*redis.Client).FlushAll().Err()
+	})
+	// what TestMain would do: keep it up between the tests
+	_, err := shared.Acquire(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(shared.Release)
+	var containerID string
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			env := shared.AcquireT(t)
+			client := env.Services["redis"].(*redis.Client)
+			// the previous subtest's key was reset
+			require.NoError(t, client.SetNX("key", name, 0).Err())
+			require.Equal(t, name, client.Get("key").Val())
+			container, err := env.GetContainer("redis")
+			require.NoError(t, err)
+			if containerID != "" {
+				require.Equal(t, containerID, container.Config.ID)
+			}
+			containerID = container.Config.ID
+		})
+	}
+}
+
+func TestRedis_Reuse(t *testing.T) {
+	config := func(reuse bool) *docker.EnvironmentConfig {
+		return testConfig(docker.EnvironmentConfig{
+			ComposeFilePaths: []string{"docker-compose.isolated.yml"},
+			ProjectName:      "reuse",
+			Reuse:            reuse,
+		})
+	}
+	start := func(vars map[string]string) string {
+		env, err := docker.StartEnvironment(config(true), &docker.ServiceEntry{
+			Name:            "redis",
+			Handler:         GetRedisClient,
+			EnvironmentVars: vars,
+		})
+		require.NoError(t, err)
+		// leaves the containers running
+		env.Shutdown()
+		container, err := env.GetContainer("redis")
+		require.NoError(t, err)
+		return container.Config.ID
+	}
+	t.Cleanup(func() {
+		// a regular environment brings the project down
+		env, err := docker.StartEnvironment(config(false))
+		require.NoError(t, err)
+		env.Shutdown()
+	})
+	first := start(nil)
+	// same configuration -> reattached
+	require.Equal(t, first, start(nil))
+	// changed configuration -> recreated
+	require.NotEqual(t, first, start(map[string]string{"REUSE_TEST": "changed"}))
+}
+
+func TestRedis_Logger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{Logger: docker.NewSlogLogger(slog.New(slog.NewJSONHandler(buf, nil)))}),
+		&docker.ServiceEntry{
+			Name:    "redis",
+			Handler: GetRedisClient,
+		},
+	)
+	require.NotNil(t, env.Services["redis"])
+	require.Contains(t, buf.String(), `"msg":"Brought up services [redis]"`)
+	require.NotContains(t, buf.String(), "\033[")
+}
+
+func TestRedis_ArtifactsDir(t *testing.T) {
+	dir := t.TempDir()
+	env, err := docker.StartEnvironment(
+		testConfig(docker.EnvironmentConfig{ArtifactsDir: dir}),
+		&docker.ServiceEntry{
+			Name:    "redis",
+			Handler: GetRedisClient,
+		},
+	)
+	require.NoError(t, err)
+	env.Shutdown()
+	logs, err := os.ReadFile(filepath.Join(dir, "redis.log"))
+	require.NoError(t, err)
+	require.Regexp(t, `(?m)^\S+ stdout .*Ready to accept connections`, string(logs))
+	for _, name := range []string{"redis.inspect.json", "environment.json", "compose.log"} {
+		require.FileExists(t, filepath.Join(dir, name))
+	}
+}
+
+func TestRedis_Partition(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{ComposeFilePaths: []string{"docker-compose.overlap.yml"}}),
+		&docker.ServiceEntry{Name: "redis"},
+		&docker.ServiceEntry{Name: "redis-replica"},
+	)
+	replica, err := env.GetContainer("redis-replica")
+	require.NoError(t, err)
+	ping := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := replica.ExecOrFail(ctx, docker.ExecOptions{Cmd: []string{"redis-cli", "-h", "redis", "ping"}})
+		return err
+	}
+	require.NoError(t, ping())
+	require.NoError(t, env.Partition([]string{"redis"}, []string{"redis-replica"}))
+	require.Error(t, ping())
+	require.NoError(t, env.Heal())
+	require.NoError(t, docker.AwaitUntil(10*time.Second, 100*time.Millisecond, ping))
+	// the same, by hand
+	require.NoError(t, replica.DisconnectNetwork("tests"))
+	require.Error(t, ping())
+	require.NoError(t, replica.ConnectNetwork("tests", "redis-replica"))
+	require.NoError(t, docker.AwaitUntil(10*time.Second, 100*time.Millisecond, ping))
+}
+
+func TestRedis_ShapeTraffic(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{
+			Name:    "redis",
+			Handler: GetRedisClient,
+		},
+	)
+	client := env.Services["redis"].(*redis.Client)
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	timePing := func() time.Duration {
+		start := time.Now()
+		require.NoError(t, client.Ping().Err())
+		return time.Since(start)
+	}
+	ctx := context.Background()
+	require.NoError(t, container.ShapeTraffic(ctx, docker.TrafficRules{Delay: 300 * time.Millisecond}))
+	require.GreaterOrEqual(t, timePing(), 300*time.Millisecond)
+	require.NoError(t, container.ResetTraffic(ctx))
+	require.Less(t, timePing(), 300*time.Millisecond)
+	// nothing to apply
+	require.Error(t, container.ShapeTraffic(ctx, docker.TrafficRules{}))
+}
+
+func TestRedis_Proxy(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{Name: "redis"},
+	)
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	endpoints, err := container.GetEndpoints()
+	require.NoError(t, err)
+	proxied, err := endpoints.Proxied(6379)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = proxied.Close() })
+	client := redis.NewClient(&redis.Options{
+		Addr:        fmt.Sprintf("%s:%d", proxied.GetHost(), proxied.GetPublicPorts(6379)[0]),
+		ReadTimeout: time.Second,
+		MaxRetries:  -1,
+	})
+	timePing := func() time.Duration {
+		start := time.Now()
+		require.NoError(t, client.Ping().Err())
+		return time.Since(start)
+	}
+	proxy := proxied.Proxy(6379)
+	proxy.AddToxic("latency", &docker.LatencyToxic{Latency: 300 * time.Millisecond})
+	require.GreaterOrEqual(t, timePing(), 300*time.Millisecond)
+	proxy.RemoveToxic("latency")
+	require.Less(t, timePing(), 300*time.Millisecond)
+	proxy.AddToxic("timeout", &docker.TimeoutToxic{})
+	require.Error(t, client.Ping().Err())
+	proxy.ResetToxics()
+	require.NoError(t, docker.AwaitUntil(5*time.Second, 100*time.Millisecond, func() error { return client.Ping().Err() }))
+	proxy.AddToxic("reset", &docker.ResetPeerToxic{})
+	require.Error(t, client.Ping().Err())
+	proxy.ResetToxics()
+	require.NoError(t, docker.AwaitUntil(5*time.Second, 100*time.Millisecond, func() error { return client.Ping().Err() }))
+}
+
+func TestRedis_Lifecycle(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{
+			Name:    "redis",
+			Handler: GetRedisClient,
+		},
+	)
+	client := env.Services["redis"].(*redis.Client)
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	require.NoError(t, client.Set("key", "value", 0).Err())
+	// frozen dependency
+	require.NoError(t, container.Pause())
+	frozen := redis.NewClient(&redis.Options{Addr: client.Options().Addr, ReadTimeout: 500 * time.Millisecond, MaxRetries: -1})
+	require.Error(t, frozen.Ping().Err())
+	require.NoError(t, container.Unpause())
+	require.NoError(t, client.Ping().Err())
+	// the same container is restarted, and the handler runs again
+	require.NoError(t, env.RestartServices(5*time.Second, "redis"))
+	restarted, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	require.Equal(t, container.Config.ID, restarted.Config.ID)
+	client = env.Services["redis"].(*redis.Client)
+	require.NoError(t, client.Ping().Err())
+	// crash
+	require.NoError(t, container.Kill("SIGKILL"))
+	require.NoError(t, docker.AwaitUntil(10*time.Second, 100*time.Millisecond, func() error {
+		if status := container.GetStatus(); status.Code != docker.Exited {
+			return fmt.Errorf("container not exited yet (%v)", status.Error)
+		}
+		return nil
+	}))
+	require.NoError(t, container.Restart(5*time.Second))
+	require.NoError(t, docker.AwaitUntil(10*time.Second, 100*time.Millisecond, func() error { return client.Ping().Err() }))
+}
+
+func TestRedis_Stats(t *testing.T) {
+	dir := t.TempDir()
+	env, err := docker.StartEnvironment(
+		testConfig(docker.EnvironmentConfig{ArtifactsDir: dir}),
+		&docker.ServiceEntry{
+			Name:    "redis",
+			Handler: GetRedisClient,
+		},
+	)
+	require.NoError(t, err)
+	defer env.Shutdown()
+	client := env.Services["redis"].(*redis.Client)
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	stats, err := container.Stats(context.Background())
+	require.NoError(t, err)
+	require.Greater(t, stats.MemoryUsage, uint64(0))
+	recorder := container.StartSampling(time.Second)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, client.Set(fmt.Sprintf("key%d", i), "value", 0).Err())
+	}
+	time.Sleep(2 * time.Second)
+	summary := recorder.Stop()
+	require.GreaterOrEqual(t, summary.Samples, 2)
+	require.Greater(t, summary.PeakMemory, uint64(0))
+	require.GreaterOrEqual(t, summary.PeakMemory, summary.AvgMemory)
+	require.Less(t, summary.PeakMemory, uint64(512<<20))
+	// the summary is part of the shutdown report
+	env.Shutdown()
+	report, err := os.ReadFile(filepath.Join(dir, "environment.json"))
+	require.NoError(t, err)
+	require.Contains(t, string(report), "peakMemory")
+}
+
+func TestRedis_Copy(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{Name: "redis"},
+	)
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	ctx := context.Background()
+	src := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(src, "script.sh"), []byte("echo hello"), 0750))
+	require.NoError(t, os.Mkdir(filepath.Join(src, "conf"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "conf", "redis.conf"), []byte("maxmemory 1mb"), 0600))
+	// a directory, and a single file
+	require.NoError(t, container.CopyToContainer(ctx, src, "/tmp/copied", docker.CopyOptions{}))
+	require.NoError(t, container.CopyToContainer(ctx, filepath.Join(src, "script.sh"), "/tmp/script.sh", docker.CopyOptions{}))
+	require.NoError(t, container.CopyReaderToContainer(ctx, strings.NewReader("in memory"), "/tmp/memory.txt", docker.CopyOptions{Mode: 0640}))
+	result, err := container.ExecOrFail(ctx, docker.ExecOptions{Cmd: []string{"stat", "-c", "%a %n", "/tmp/copied/script.sh", "/tmp/copied/conf/redis.conf", "/tmp/script.sh", "/tmp/memory.txt"}})
+	require.NoError(t, err)
+	require.Equal(t, "750 /tmp/copied/script.sh\n600 /tmp/copied/conf/redis.conf\n750 /tmp/script.sh\n640 /tmp/memory.txt", strings.TrimSpace(result.Stdout))
+	// and back
+	dst := filepath.Join(t.TempDir(), "copied")
+	require.NoError(t, container.CopyFromContainer(ctx, "/tmp/copied", dst, docker.CopyOptions{}))
+	for name, expected := range map[string]struct {
+		content string
+		mode    os.FileMode
+	}{
+		"script.sh":       {"echo hello", 0750},
+		"conf/redis.conf": {"maxmemory 1mb", 0600},
+	} {
+		path := filepath.Join(dst, filepath.FromSlash(name))
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, expected.content, string(content))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, expected.mode, info.Mode().Perm(), name)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, container.CopyFileFromContainer(ctx, "/tmp/memory.txt", &buf))
+	require.Equal(t, "in memory", buf.String())
+}
+
+// syncBuffer a bytes.Buffer safe for the concurrent writes of streamed logs
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestRedis_FollowLogs(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{Name: "redis"},
+	)
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	lines, err := container.FollowLogs(ctx, docker.LogOptions{Stream: docker.Stdout})
+	require.NoError(t, err)
+	next := func(pattern string) docker.LogLine {
+		for line := range lines {
+			if strings.Contains(line.Text, pattern) {
+				return line
+			}
+		}
+		require.FailNow(t, "log stream ended", "expected a line containing %q", pattern)
+		return docker.LogLine{}
+	}
+	ready := next("Ready to accept connections")
+	require.Equal(t, docker.Stdout, ready.Stream)
+	require.False(t, ready.Timestamp.IsZero())
+	// new lines are streamed as they are logged
+	_, err = container.ExecOrFail(ctx, docker.ExecOptions{Cmd: []string{"redis-cli", "save"}})
+	require.NoError(t, err)
+	saved := next("DB saved on disk")
+	require.False(t, saved.Timestamp.Before(ready.Timestamp))
+	// the stream ends with the context
+	cancel()
+	for range lines {
+	}
+}
+
+func TestRedis_StreamLogs(t *testing.T) {
+	buf := new(syncBuffer)
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{
+			StreamLogs: true,
+			Logger:     docker.NewSlogLogger(slog.New(slog.NewTextHandler(buf, nil))),
+		}),
+		&docker.ServiceEntry{Name: "redis"},
+	)
+	require.NoError(t, docker.AwaitUntil(10*time.Second, 100*time.Millisecond, func() error {
+		if !strings.Contains(buf.String(), "[redis] ") || !strings.Contains(buf.String(), "Ready to accept connections") {
+			return errors.New("logs not streamed yet")
+		}
+		return nil
+	}))
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	_, err = container.ExecOrFail(context.Background(), docker.ExecOptions{Cmd: []string{"redis-cli", "save"}})
+	require.NoError(t, err)
+	require.NoError(t, docker.AwaitUntil(10*time.Second, 100*time.Millisecond, func() error {
+		if !strings.Contains(buf.String(), "DB saved on disk") {
+			return errors.New("new logs not streamed yet")
+		}
+		return nil
+	}))
+}
+
+func TestRedis_WaitForLog(t *testing.T) {
+	env := docker.StartEnvironmentT(t,
+		testConfig(docker.EnvironmentConfig{}),
+		&docker.ServiceEntry{Name: "redis"},
+	)
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	matched, err := container.WaitForLog(ctx, regexp.MustCompile("Ready to accept connections"), 1)
+	require.NoError(t, err)
+	require.Len(t, matched, 1)
+	// waits for the lines to come
+	go func() {
+		for i := 0; i < 2; i++ {
+			_, _ = container.ExecOrFail(ctx, docker.ExecOptions{Cmd: []string{"redis-cli", "save"}})
+		}
+	}()
+	matched, err = container.WaitForLog(ctx, regexp.MustCompile("DB saved on disk"), 2)
+	require.NoError(t, err)
+	require.Len(t, matched, 2)
+	// times out, reporting the last lines
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shortCancel()
+	matched, err = container.WaitForLog(shortCtx, regexp.MustCompile("never logged"), 1)
+	require.Empty(t, matched)
+	var waitErr *docker.LogWaitError
+	require.ErrorAs(t, err, &waitErr)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NotEmpty(t, waitErr.Tail)
+	require.Contains(t, err.Error(), "DB saved on disk")
+}
+
+// recordingTB a docker.TB recording the test's log, whose cleanups run on demand
+type recordingTB struct {
+	syncBuffer
+	cleanups []func()
+}
+
+func (tb *recordingTB) Helper()                                   {}
+func (tb *recordingTB) Cleanup(f func())                          { tb.cleanups = append(tb.cleanups, f) }
+func (tb *recordingTB) Errorf(format string, args ...interface{}) { tb.Logf(format, args...) }
+func (tb *recordingTB) Fatalf(format string, args ...interface{}) { tb.Logf(format, args...) }
+func (tb *recordingTB) FailNow()                                  {}
+func (tb *recordingTB) Failed() bool                              { return false }
+func (tb *recordingTB) Log(args ...interface{})                   { _, _ = fmt.Fprintln(tb, args...) }
+func (tb *recordingTB) Logf(format string, args ...interface{})   { tb.Log(fmt.Sprintf(format, args...)) }
+
+// complete runs the cleanups, like the testing package once the test completed
+func (tb *recordingTB) complete() {
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}
+
+func TestRedis_TestLogger(t *testing.T) {
+	global := new(syncBuffer)
+	docker.SetLogger(docker.NewSlogLogger(slog.New(slog.NewTextHandler(global, nil))))
+	t.Cleanup(func() {
+		docker.SetLogger(nil)
+	})
+	tb := new(recordingTB)
+	env, err := docker.StartEnvironment(
+		testConfig(docker.EnvironmentConfig{
+			StreamLogs: true,
+			Logger:     docker.NewTestLogger(tb),
+		}),
+		&docker.ServiceEntry{Name: "redis"},
+	)
+	require.NoError(t, err)
+	defer env.Shutdown()
+	// the compose CLI's output, the messages and the streamed logs all go through the test
+	require.Regexp(t, `(?i)redis.*creat|creat.*redis`, tb.String())
+	require.Contains(t, tb.String(), "Brought up services [redis]")
+	require.NoError(t, docker.AwaitUntil(10*time.Second, 100*time.Millisecond, func() error {
+		if !strings.Contains(tb.String(), "[redis] ") {
+			return errors.New("no streamed log line yet")
+		}
+		return nil
+	}))
+	require.NotContains(t, global.String(), "[redis] ")
+	// once the test completed, the logs still streamed go to the global logger instead
+	tb.complete()
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	_, err = container.ExecOrFail(context.Background(), docker.ExecOptions{Cmd: []string{"redis-cli", "save"}})
+	require.NoError(t, err)
+	require.NoError(t, docker.AwaitUntil(10*time.Second, 100*time.Millisecond, func() error {
+		if !strings.Contains(global.String(), "DB saved on disk") {
+			return errors.New("save not logged yet")
+		}
+		return nil
+	}))
+	require.NotContains(t, tb.String(), "DB saved on disk")
+}
+
+func TestCompose_BrokenEventStream(t *testing.T) {
+	compose, err := docker.NewCompose(docker.ComposeConfig{
+		Env: testConfig(docker.EnvironmentConfig{UniqueProjectName: true}),
+		Services: map[string]*docker.ServiceConfig{
+			"redis": {Name: "redis", Network: "tests"},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, compose.Up())
+	// stops listening to the events: the state changes must be polled from now on
+	compose.Close()
+	require.NoError(t, compose.Stop("redis"))
+	containers, err := compose.GetContainers("redis")
+	require.NoError(t, err)
+	require.Empty(t, containers)
+	require.NoError(t, compose.Start(&docker.ServiceConfig{Name: "redis", Network: "tests"}))
+	container, err := compose.GetContainer("redis")
+	require.NoError(t, err)
+	require.Equal(t, docker.Running, container.GetStatus().Code)
+	require.NoError(t, compose.Down())
+}
+
+// composeProcesses the PIDs of the running processes whose command line mentions the project
+func composeProcesses(project string) []string {
+	entries, _ := os.ReadDir("/proc")
+	var pids []string
+	for _, entry := range entries {
+		cmdline, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		if strings.Contains(string(cmdline), project) && entry.Name() != strconv.Itoa(os.Getpid()) {
+			pids = append(pids, entry.Name())
+		}
+	}
+	return pids
+}
+
+func TestCompose_UpContextCanceled(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("lists the processes through /proc")
+	}
+	compose, err := docker.NewCompose(docker.ComposeConfig{
+		Env: testConfig(docker.EnvironmentConfig{UniqueProjectName: true}),
+		Services: map[string]*docker.ServiceConfig{
+			"redis": {Name: "redis", Network: "tests"},
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, compose.Down())
+		compose.Close()
+	}()
+	project := compose.ProjectName()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// cancel once the compose process is running
+	go func() {
+		_ = docker.AwaitUntil(10*time.Second, 10*time.Millisecond, func() error {
+			if len(composeProcesses(project)) == 0 {
+				return errors.New("compose not running yet")
+			}
+			return nil
+		})
+		cancel()
+	}()
+	require.Error(t, compose.UpContext(ctx))
+	require.NoError(t, docker.AwaitUntil(5*time.Second, 100*time.Millisecond, func() error {
+		if pids := composeProcesses(project); len(pids) > 0 {
+			return fmt.Errorf("compose processes still running: %v", pids)
+		}
+		return nil
+	}))
+}
+
+// envHelper selects what TestHelperProcess does, when run as a child process of a test
+const envHelper = "GO_COMPOSE_TEST_HELPER"
+
+// TestHelperProcess not a test: the child process of the tests needing an environment in a process of their own. It
+// prints the environment's project, then waits to be killed or signaled
+func TestHelperProcess(t *testing.T) {
+	config := testConfig(docker.EnvironmentConfig{UniqueProjectName: true})
+	entry := &docker.ServiceEntry{Name: "redis"}
+	switch os.Getenv(envHelper) {
+	case "reap":
+		config.Reap = true
+	case "signals":
+		docker.HandleSignals()
+		entry.After = func() {
+			fmt.Println("after handler ran")
+		}
+	default:
+		t.Skip("only run as a child process")
+	}
+	env, err := docker.StartEnvironment(config, entry)
+	require.NoError(t, err)
+	container, err := env.GetContainer("redis")
+	require.NoError(t, err)
+	fmt.Printf("project=%s\n", container.Config.Labels[docker.ProjectLabel])
+	select {}
+}
+
+// startHelperProcess runs TestHelperProcess in the mode, and returns the process, its output, and the project of its
+// environment
+func startHelperProcess(t *testing.T, mode string) (*exec.Cmd, *syncBuffer, string) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), envHelper+"="+mode)
+	output := new(syncBuffer)
+	cmd.Stdout, cmd.Stderr = output, output
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	project := regexp.MustCompile(`(?m)^project=(\S+)$`)
+	require.NoError(t, docker.AwaitUntil(60*time.Second, 100*time.Millisecond, func() error {
+		if !project.MatchString(output.String()) {
+			return errors.New("environment not started yet")
+		}
+		return nil
+	}), output.String())
+	return cmd, output, project.FindStringSubmatch(output.String())[1]
+}
+
+// projectContainers the containers of the project's redis service
+func projectContainers(t *testing.T, project string) []*docker.Container {
+	compose, err := docker.NewCompose(docker.ComposeConfig{
+		Env: &docker.EnvironmentConfig{
+			ComposeFilePaths: []string{"docker-compose.tests.yml"},
+			ProjectName:      project,
+		},
+	})
+	require.NoError(t, err)
+	defer compose.Close()
+	containers, err := compose.GetContainers("redis")
+	require.NoError(t, err)
+	return containers
+}
+
+func TestReaper(t *testing.T) {
+	cmd, _, project := startHelperProcess(t, "reap")
+	require.NotEmpty(t, projectContainers(t, project))
+	// dies without shutting down: the reaper must remove the project
+	require.NoError(t, cmd.Process.Kill())
+	_ = cmd.Wait()
+	require.NoError(t, docker.AwaitUntil(60*time.Second, 500*time.Millisecond, func() error {
+		if containers := projectContainers(t, project); len(containers) > 0 {
+			return fmt.Errorf("%d containers of project %s left", len(containers), project)
+		}
+		return nil
+	}))
+}
+
+func TestHandleSignals(t *testing.T) {
+	cmd, output, project := startHelperProcess(t, "signals")
+	require.NotEmpty(t, projectContainers(t, project))
+	require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+	// the environment is shut down before exiting
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, cmd.Wait(), &exitErr, output.String())
+	require.Equal(t, 1, exitErr.ExitCode())
+	require.Contains(t, output.String(), "after handler ran")
+	require.Empty(t, projectContainers(t, project))
+}